@@ -12,16 +12,9 @@ const ModuleName = "fts-index"
 
 var IdxType redismodule.ModuleType
 
-type Index struct {
-	Name     string
-	Prefixes []string
-	Schema   []Field
-}
-
-type Field struct {
-	Name string
-	Type string
-}
+// Index is stored as a module type value and cast from unsafe.Pointer,
+// so it must be the same type that FT.CREATE arguments are parsed into
+type Index = idxmodel.Index
 
 func main() {
 	panic("Not intended for run")
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/kuzznya/go-redis-search-replica/pkg/exec"
+	"github.com/kuzznya/go-redis-search-replica/pkg/memory"
 	"github.com/kuzznya/go-redis-search-replica/pkg/rdb"
 	"github.com/kuzznya/go-redis-search-replica/pkg/resp"
 	"github.com/kuzznya/go-redis-search-replica/pkg/search"
@@ -19,6 +20,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,6 +32,27 @@ func main() {
 		"--replicaof localhost:6379 - set master url to localhost:6379")
 	var port int
 	flag.IntVar(&port, "port", -1, "--port 6379 - set replica listening port to 6379")
+	var storageType string
+	flag.StringVar(&storageType, "storage", "",
+		"--storage disk - keep documents in an append-only log on disk instead of memory")
+	var storagePath string
+	flag.StringVar(&storagePath, "storage-path", "",
+		"--storage-path /data/documents.log - set path of the disk storage log")
+	var maxMemory string
+	flag.StringVar(&maxMemory, "maxmemory", "",
+		"--maxmemory 2gb - set memory budget for documents and indexes to 2 GiB")
+	var maxMemoryPolicy string
+	flag.StringVar(&maxMemoryPolicy, "maxmemory-policy", "",
+		"--maxmemory-policy evict-unindexed - set policy applied when the memory budget is exceeded, "+
+			"one of reject-create, drop-unindexed, evict-unindexed")
+	var minPrefix int
+	flag.IntVar(&minPrefix, "min-prefix", -1, "--min-prefix 2 - set minimum length of prefix queries to 2")
+	var maxExpansions int
+	flag.IntVar(&maxExpansions, "max-expansions", -1,
+		"--max-expansions 200 - expand prefix queries to at most 200 terms")
+	var defaultDialect int
+	flag.IntVar(&defaultDialect, "default-dialect", -1,
+		"--default-dialect 2 - set query dialect used when FT.SEARCH has no DIALECT option to 2")
 	flag.Parse()
 	if logLevel == "" {
 		logLevel = os.Getenv("LOG_LEVEL")
@@ -60,8 +83,87 @@ func main() {
 		port = 16379
 	}
 
-	s := storage.New()
-	engine := search.NewEngine(s)
+	if storageType == "" {
+		storageType = os.Getenv("STORAGE")
+	}
+	if storagePath == "" {
+		storagePath = os.Getenv("STORAGE_PATH")
+	}
+	if storagePath == "" {
+		storagePath = "documents.log"
+	}
+
+	var s storage.Storage
+	switch strings.ToLower(storageType) {
+	case "", "memory":
+		s = storage.New()
+	case "disk":
+		s, err = storage.NewDisk(storagePath)
+		if err != nil {
+			log.WithError(err).Panicln("Failed to create disk storage")
+		}
+		log.Infof("Storing documents on disk in %s", storagePath)
+	default:
+		log.Panicf("Unknown storage type '%s', expected 'memory' or 'disk'", storageType)
+	}
+
+	if maxMemory == "" {
+		maxMemory = os.Getenv("MAXMEMORY")
+	}
+	if maxMemory == "" {
+		maxMemory = "0"
+	}
+	memoryLimit, err := memory.ParseSize(maxMemory)
+	if err != nil {
+		log.WithError(err).Panicln("Failed to parse maxmemory")
+	}
+	if maxMemoryPolicy == "" {
+		maxMemoryPolicy = os.Getenv("MAXMEMORY_POLICY")
+	}
+	if maxMemoryPolicy == "" {
+		maxMemoryPolicy = string(memory.RejectCreate)
+	}
+	policy, err := memory.ParsePolicy(maxMemoryPolicy)
+	if err != nil {
+		log.WithError(err).Panicln("Failed to parse maxmemory policy")
+	}
+	budget := memory.NewBudget(memoryLimit, policy)
+	budget.Track("storage", s.MemoryUsage)
+
+	cfg := search.DefaultConfig()
+	if minPrefix == -1 && os.Getenv("MINPREFIX") != "" {
+		minPrefix, err = strconv.Atoi(os.Getenv("MINPREFIX"))
+		if err != nil {
+			log.WithError(err).Panicln("Failed to parse min prefix from environment variable MINPREFIX")
+		}
+	}
+	if minPrefix != -1 {
+		cfg.MinPrefix = minPrefix
+	}
+	if maxExpansions == -1 && os.Getenv("MAXEXPANSIONS") != "" {
+		maxExpansions, err = strconv.Atoi(os.Getenv("MAXEXPANSIONS"))
+		if err != nil {
+			log.WithError(err).Panicln("Failed to parse max expansions from environment variable MAXEXPANSIONS")
+		}
+	}
+	if maxExpansions != -1 {
+		cfg.MaxExpansions = maxExpansions
+	}
+
+	if defaultDialect == -1 && os.Getenv("DEFAULT_DIALECT") != "" {
+		defaultDialect, err = strconv.Atoi(os.Getenv("DEFAULT_DIALECT"))
+		if err != nil {
+			log.WithError(err).Panicln("Failed to parse default dialect from environment variable DEFAULT_DIALECT")
+		}
+	}
+	if defaultDialect != -1 {
+		if defaultDialect < 1 || defaultDialect > search.MaxDialect {
+			log.Panicf("Unsupported default dialect %d, expected 1 to %d", defaultDialect, search.MaxDialect)
+		}
+		cfg.DefaultDialect = defaultDialect
+	}
+
+	engine := search.NewEngine(s, budget, cfg)
 	e := exec.New(s, engine)
 
 	dialTimeout := 30 * time.Second
@@ -98,7 +200,7 @@ func main() {
 		for {
 			// NB: We report offset - 1 so that replica is never in full sync from the master POV,
 			// so master never tries to failover to this node
-			ackOffset := offset - 1
+			ackOffset := atomic.LoadUint64(&offset) - 1
 			if ackOffset < 0 {
 				ackOffset = 0
 			}
@@ -109,10 +211,10 @@ func main() {
 
 	rdbReadStart := time.Now()
 
-	rdbLen := readRdb(reader, e)
+	rdbLen := readRdb(reader, e, offset)
 	log.Infof("RDB content received successfully (%d bytes) in %s", rdbLen, time.Now().Sub(rdbReadStart).String())
 
-	go server.StartServer(engine, port) // TODO: 03/05/2023 check if it is better to start server in the beginning or here
+	go server.StartServer(engine, s, budget, port) // TODO: 03/05/2023 check if it is better to start server in the beginning or here
 
 	parser := resp.NewParser(reader)
 	for {
@@ -129,16 +231,16 @@ func main() {
 			log.WithError(err).Panicln("Error while reading replication data")
 		}
 
+		atomic.AddUint64(&offset, read) // offset is read concurrently by REPLCONF ACK loop
+
 		if cmd != nil {
 			log.Infof("Cmd: %s", cmd.Name())
 			log.Debugf("Cmd args: %+v", cmd)
-			err := e.Exec(cmd)
+			err := e.Exec(cmd, offset)
 			if err != nil {
 				log.WithError(err).Panicln("Failed to execute command")
 			}
 		}
-
-		offset += read
 	}
 }
 
@@ -192,7 +294,7 @@ func execReplconf(c *redis.Client) {
 		log.WithError(err).Panicln("Failed to run REPLCONF command")
 	}
 	if strings.ToLower(replconf.Val().(string)) != "ok" {
-		log.Panicln("Unknown response to REPLCONF: %s", replconf.Val())
+		log.Panicf("Unknown response to REPLCONF: %s", replconf.Val())
 	}
 }
 
@@ -217,7 +319,7 @@ func replconfAck(writer *bufio.Writer, conn *net.TCPConn, offset uint64) {
 	}
 }
 
-func readRdb(reader *bufio.Reader, e exec.Executor) uint64 {
+func readRdb(reader *bufio.Reader, e exec.Executor, offset uint64) uint64 {
 	var line []byte
 	var err error
 	for {
@@ -237,7 +339,7 @@ func readRdb(reader *bufio.Reader, e exec.Executor) uint64 {
 		log.WithError(err).Panicln("Failed to parse RDB size")
 	}
 
-	err = rdb.Parse(reader, e)
+	err = rdb.Parse(reader, e, offset)
 	if err != nil {
 		log.WithError(err).Panicf("Failed to parse RDB: %+v", err)
 	}
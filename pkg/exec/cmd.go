@@ -5,6 +5,7 @@ import (
 	"github.com/kuzznya/go-redis-search-replica/pkg/search"
 	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	"strconv"
 )
 
@@ -53,16 +54,13 @@ func (c HSetCmd) Name() string {
 	return Hset
 }
 
-func (c HSetCmd) exec(s storage.Storage, _ search.Engine) error {
-	o, found := s.Get(c.Key)
-	h := o.Hash
-	if !found {
-		h = storage.Hash{}
-	}
+func (c HSetCmd) exec(s storage.Storage, e search.Engine) error {
+	o, _ := s.Get(c.Key)
+	fields := make(storage.Hash, len(c.Args))
 	for _, arg := range c.Args {
-		h[arg.Field] = arg.Value
+		fields[arg.Field] = arg.Value
 	}
-	s.Save(c.Key, h)
+	save(s, e, c.Key, o.Hash, fields)
 	return nil
 }
 
@@ -76,16 +74,12 @@ func (c HsetnxCmd) Name() string {
 	return Hsetnx
 }
 
-func (c HsetnxCmd) exec(s storage.Storage, _ search.Engine) error {
-	o, found := s.Get(c.Key)
-	h := o.Hash
-	if !found {
-		h = storage.Hash{}
-	} else if _, found := h[c.Field]; found {
+func (c HsetnxCmd) exec(s storage.Storage, e search.Engine) error {
+	o, _ := s.Get(c.Key)
+	if _, found := o.Hash[c.Field]; found {
 		return nil
 	}
-	h[c.Field] = c.Value
-	s.Save(c.Key, h)
+	save(s, e, c.Key, o.Hash, storage.Hash{c.Field: c.Value})
 	return nil
 }
 
@@ -99,15 +93,11 @@ func (c HincrbyCmd) Name() string {
 	return Hincrby
 }
 
-func (c HincrbyCmd) exec(s storage.Storage, _ search.Engine) error {
-	o, found := s.Get(c.Key)
-	h := o.Hash
-	if !found {
-		h = storage.Hash{}
-	}
+func (c HincrbyCmd) exec(s storage.Storage, e search.Engine) error {
+	o, _ := s.Get(c.Key)
 
 	val := int64(0)
-	if prev, found := h[c.Field]; found {
+	if prev, found := o.Hash[c.Field]; found {
 		parsed, err := strconv.ParseInt(string(prev), 10, 64)
 		if err != nil {
 			return errors.New("hash value is not an integer")
@@ -116,9 +106,7 @@ func (c HincrbyCmd) exec(s storage.Storage, _ search.Engine) error {
 	}
 	val += c.Value
 
-	h[c.Field] = []byte(strconv.FormatInt(val, 10))
-
-	s.Save(c.Key, h)
+	save(s, e, c.Key, o.Hash, storage.Hash{c.Field: []byte(strconv.FormatInt(val, 10))})
 
 	return nil
 }
@@ -132,9 +120,9 @@ func (c HDelCmd) Name() string {
 	return Hdel
 }
 
-func (c HDelCmd) exec(s storage.Storage, _ search.Engine) error {
+func (c HDelCmd) exec(s storage.Storage, e search.Engine) error {
 	o, found := s.Get(c.Key)
-	h := o.Hash
+	h := o.Hash.Clone()
 	if !found {
 		return nil
 	}
@@ -144,6 +132,7 @@ func (c HDelCmd) exec(s storage.Storage, _ search.Engine) error {
 	if len(h) == 0 {
 		s.Delete(c.Key)
 	} else {
+		// removing fields does not need to be admitted by the memory budget
 		s.Save(c.Key, h)
 	}
 	return nil
@@ -193,6 +182,28 @@ func (c RenamenxCmd) exec(s storage.Storage, _ search.Engine) error {
 	return nil
 }
 
+// save sets the fields admitted by the memory budget policy on the stored hash, nil if the key is not stored.
+// The key is evicted if the document is not admitted, it is left as is if all the fields are dropped.
+func save(s storage.Storage, e search.Engine, key string, stored storage.Hash, fields storage.Hash) {
+	fields, ok := e.Admit(key, fields)
+	if !ok {
+		s.Delete(key)
+		return
+	}
+	if len(fields) == 0 {
+		return
+	}
+	// previous version of the document can still be read by snapshots
+	h := make(storage.Hash, len(stored)+len(fields))
+	for k, v := range stored {
+		h[k] = v
+	}
+	for k, v := range fields {
+		h[k] = v
+	}
+	s.Save(key, h)
+}
+
 type FtCreateCmd struct {
 	Index idxmodel.Index
 }
@@ -206,10 +217,9 @@ func (c FtCreateCmd) exec(_ storage.Storage, engine search.Engine) error {
 	if prefixes == nil || len(prefixes) == 0 {
 		prefixes = []string{"*"}
 	}
-	fields := make([]string, len(c.Index.Schema))
-	for i, f := range c.Index.Schema {
-		fields[i] = f.Name
+	if err := engine.CreateIndex(c.Index.Name, prefixes, c.Index.Schema); err != nil {
+		// rejecting the index must not stop the replication
+		log.WithError(err).Warnf("Index %s is not created", c.Index.Name)
 	}
-	engine.CreateIndex(c.Index.Name, prefixes, fields)
 	return nil
 }
@@ -0,0 +1,133 @@
+package exec
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/memory"
+	"github.com/kuzznya/go-redis-search-replica/pkg/search"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sync/atomic"
+	"testing"
+)
+
+// budgetTest replicates commands to the storage with the index over doc: keys, the budget is exceeded on demand
+type budgetTest struct {
+	t        *testing.T
+	s        storage.Storage
+	e        search.Engine
+	x        Executor
+	offset   uint64
+	exceeded int64
+}
+
+func newBudgetTest(t *testing.T, policy memory.Policy) *budgetTest {
+	b := &budgetTest{t: t, s: storage.NewMemory()}
+	budget := memory.NewBudget(1, policy)
+	budget.Track("test", func() int64 {
+		return atomic.LoadInt64(&b.exceeded)
+	})
+	b.e = search.NewEngine(b.s, budget, search.DefaultConfig())
+	b.x = New(b.s, b.e)
+	b.exec(FtCreateCmd{Index: idxmodel.Index{Name: "idx", Prefixes: []string{"doc:"}, Schema: []idxmodel.Field{
+		{Name: "title", Type: idxmodel.TypeText},
+		{Name: "n", Type: idxmodel.TypeNumeric},
+	}}})
+	return b
+}
+
+func (b *budgetTest) exceed() {
+	atomic.StoreInt64(&b.exceeded, 1)
+}
+
+func (b *budgetTest) exec(cmd Command) {
+	b.t.Helper()
+	b.offset++
+	if err := b.x.Exec(cmd, b.offset); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+func (b *budgetTest) hset(key string, fields ...string) {
+	b.t.Helper()
+	args := make([]HSetArg, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		args = append(args, HSetArg{Field: fields[i], Value: []byte(fields[i+1])})
+	}
+	b.exec(HSetCmd{Key: key, Args: args})
+}
+
+// assertHash checks the stored fields, no fields means the key must not be stored
+func (b *budgetTest) assertHash(key string, fields ...string) {
+	b.t.Helper()
+	doc, found := b.s.Get(key)
+	if len(fields) == 0 {
+		if found {
+			b.t.Errorf("expected %s not to be stored, got %v", key, doc.Hash)
+		}
+		return
+	}
+	if !found {
+		b.t.Fatalf("expected %s to be stored", key)
+	}
+	if len(doc.Hash) != len(fields)/2 {
+		b.t.Errorf("expected %s to have fields %v, got %v", key, fields, doc.Hash)
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if v := string(doc.Hash[fields[i]]); v != fields[i+1] {
+			b.t.Errorf("expected %s field %s to be %q, got %q", key, fields[i], fields[i+1], v)
+		}
+	}
+}
+
+func TestRejectCreatePolicy(t *testing.T) {
+	b := newBudgetTest(t, memory.RejectCreate)
+	b.exceed()
+	b.hset("doc:1", "title", "hello", "extra", "kept")
+	b.hset("other:1", "title", "hello")
+	b.assertHash("doc:1", "title", "hello", "extra", "kept")
+	b.assertHash("other:1", "title", "hello")
+
+	b.exec(FtCreateCmd{Index: idxmodel.Index{Name: "rejected", Schema: []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}}})
+	if _, err := b.e.Stats("rejected", 0); err == nil {
+		t.Error("expected the index to be rejected")
+	}
+}
+
+func TestDropUnindexedPolicy(t *testing.T) {
+	b := newBudgetTest(t, memory.DropUnindexed)
+	b.hset("doc:1", "title", "hello", "extra", "stored before")
+	b.hset("other:1", "title", "hello")
+	b.exceed()
+
+	// incoming unindexed fields are dropped, stored ones are kept
+	b.hset("doc:1", "n", "5", "more", "dropped")
+	b.assertHash("doc:1", "title", "hello", "extra", "stored before", "n", "5")
+	b.exec(HincrbyCmd{Key: "doc:1", Field: "n", Value: 2})
+	b.exec(HsetnxCmd{Key: "doc:1", Field: "more", Value: []byte("dropped")})
+	b.assertHash("doc:1", "title", "hello", "extra", "stored before", "n", "7")
+	b.exec(HDelCmd{Key: "doc:1", Fields: []string{"n"}})
+	b.assertHash("doc:1", "title", "hello", "extra", "stored before")
+
+	// keys outside of the index are neither deleted nor changed
+	b.hset("other:1", "title", "changed")
+	b.assertHash("other:1", "title", "hello")
+	b.hset("other:2", "title", "hello")
+	b.assertHash("other:2")
+}
+
+func TestEvictUnindexedPolicy(t *testing.T) {
+	b := newBudgetTest(t, memory.EvictUnindexed)
+	b.hset("doc:1", "title", "hello", "extra", "kept")
+	b.hset("other:1", "title", "hello")
+	b.hset("other:2", "title", "hello")
+	b.exceed()
+
+	// the first command after exceeding the budget evicts keys outside of the index
+	b.hset("doc:2", "title", "hello", "extra", "kept")
+	b.assertHash("doc:1", "title", "hello", "extra", "kept")
+	b.assertHash("doc:2", "title", "hello", "extra", "kept")
+	b.assertHash("other:1")
+	b.assertHash("other:2")
+
+	b.hset("other:3", "title", "hello")
+	b.assertHash("other:3")
+}
@@ -14,6 +14,13 @@ func New(s storage.Storage, e search.Engine) Executor {
 	return Executor{s: s, engine: e}
 }
 
-func (e Executor) Exec(cmd Command) error {
-	return cmd.exec(e.s, e.engine)
+// Exec applies the command, the changes are tagged with and become visible at the given replication offset
+func (e Executor) Exec(cmd Command, offset uint64) error {
+	e.s.Begin(offset)
+	defer e.s.Commit()
+	if err := cmd.exec(e.s, e.engine); err != nil {
+		return err
+	}
+	e.engine.EvictUnindexed()
+	return nil
 }
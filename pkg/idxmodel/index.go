@@ -6,6 +6,29 @@ import (
 	"strings"
 )
 
+const (
+	TypeText    = "text"
+	TypeNumeric = "numeric"
+	TypeTag     = "tag"
+	TypeGeo     = "geo"
+	TypeVector  = "vector"
+)
+
+const (
+	AlgorithmFlat = "FLAT"
+	AlgorithmHNSW = "HNSW"
+
+	VectorFloat32 = "FLOAT32"
+	VectorFloat64 = "FLOAT64"
+
+	MetricL2     = "L2"
+	MetricIP     = "IP"
+	MetricCosine = "COSINE"
+)
+
+// DefaultSeparator splits values of tag fields if no separator is set
+const DefaultSeparator = ","
+
 type Index struct {
 	Name     string
 	Prefixes []string
@@ -13,8 +36,23 @@ type Index struct {
 }
 
 type Field struct {
-	Name string
-	Type string
+	Name           string
+	Type           string
+	Separator      string         `json:",omitempty"`
+	CaseSensitive  bool           `json:",omitempty"`
+	WithSuffixTrie bool           `json:",omitempty"`
+	Sortable       bool           `json:",omitempty"`
+	Vector         *VectorOptions `json:",omitempty"`
+}
+
+type VectorOptions struct {
+	Algorithm      string
+	Type           string
+	Dim            int
+	Metric         string
+	M              int `json:",omitempty"`
+	EfConstruction int `json:",omitempty"`
+	EfRuntime      int `json:",omitempty"`
 }
 
 func Parse(next func() (string, bool), checkNext func(expected string) bool) (*Index, error) {
@@ -60,12 +98,130 @@ func Parse(next func() (string, bool), checkNext func(expected string) bool) (*I
 		if !ok {
 			return nil, errors.New("ERR field type not defined")
 		}
-		if strings.ToLower(fieldType) != "text" {
+		fieldType = strings.ToLower(fieldType)
+		if fieldType != TypeText && fieldType != TypeNumeric && fieldType != TypeTag && fieldType != TypeGeo &&
+			fieldType != TypeVector {
 			return nil, errors.New("ERR unknown field type")
 		}
 
-		schema = append(schema, Field{Name: field, Type: strings.ToLower(fieldType)})
+		f := Field{Name: field, Type: fieldType}
+		if fieldType == TypeText {
+			parseTextOptions(&f, checkNext)
+		}
+		if fieldType == TypeTag {
+			if err := parseTagOptions(&f, next, checkNext); err != nil {
+				return nil, err
+			}
+		}
+		if fieldType == TypeNumeric || fieldType == TypeGeo {
+			f.Sortable = checkNext("sortable")
+		}
+		if fieldType == TypeVector {
+			opts, err := parseVectorOptions(f.Name, next)
+			if err != nil {
+				return nil, err
+			}
+			f.Vector = opts
+		}
+		schema = append(schema, f)
 	}
 
 	return &Index{Name: name, Prefixes: prefixes, Schema: schema}, nil
 }
+
+func parseTextOptions(f *Field, checkNext func(expected string) bool) {
+	for {
+		switch {
+		case checkNext("withsuffixtrie"):
+			f.WithSuffixTrie = true
+		case checkNext("sortable"):
+			f.Sortable = true
+		default:
+			return
+		}
+	}
+}
+
+func parseTagOptions(f *Field, next func() (string, bool), checkNext func(expected string) bool) error {
+	f.Separator = DefaultSeparator
+	for {
+		switch {
+		case checkNext("separator"):
+			sep, ok := next()
+			if !ok || len(sep) != 1 {
+				return errors.Errorf("ERR tag separator of field %s must be a single character", f.Name)
+			}
+			f.Separator = sep
+		case checkNext("casesensitive"):
+			f.CaseSensitive = true
+		case checkNext("sortable"):
+			f.Sortable = true
+		default:
+			return nil
+		}
+	}
+}
+
+// parseVectorOptions parses the algorithm followed by the number of its attribute arguments and the attributes,
+// e.g. HNSW 6 TYPE FLOAT32 DIM 128 DISTANCE_METRIC COSINE
+func parseVectorOptions(field string, next func() (string, bool)) (*VectorOptions, error) {
+	algorithm, ok := next()
+	algorithm = strings.ToUpper(algorithm)
+	if !ok || algorithm != AlgorithmFlat && algorithm != AlgorithmHNSW {
+		return nil, errors.Errorf("ERR vector field %s algorithm must be FLAT or HNSW", field)
+	}
+	countStr, ok := next()
+	count, err := strconv.Atoi(countStr)
+	if !ok || err != nil || count < 0 || count%2 != 0 {
+		return nil, errors.Errorf("ERR bad number of vector field %s attributes", field)
+	}
+
+	opts := &VectorOptions{Algorithm: algorithm}
+	for i := 0; i < count/2; i++ {
+		attr, ok := next()
+		if !ok {
+			return nil, errors.Errorf("ERR number of vector field %s attributes less than defined num %d", field, count)
+		}
+		value, ok := next()
+		if !ok {
+			return nil, errors.Errorf("ERR vector field %s attribute %s value not provided", field, attr)
+		}
+		attr = strings.ToUpper(attr)
+		switch attr {
+		case "TYPE":
+			opts.Type = strings.ToUpper(value)
+			if opts.Type != VectorFloat32 && opts.Type != VectorFloat64 {
+				return nil, errors.Errorf("ERR unsupported vector type %s", value)
+			}
+		case "DISTANCE_METRIC":
+			opts.Metric = strings.ToUpper(value)
+			if opts.Metric != MetricL2 && opts.Metric != MetricIP && opts.Metric != MetricCosine {
+				return nil, errors.Errorf("ERR unsupported distance metric %s", value)
+			}
+		case "INITIAL_CAP", "BLOCK_SIZE", "EPSILON":
+			// allocation and range query hints are accepted for compatibility, but not used
+		case "DIM", "M", "EF_CONSTRUCTION", "EF_RUNTIME":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, errors.Errorf("ERR vector field %s attribute %s must be a positive integer", field, attr)
+			}
+			switch attr {
+			case "DIM":
+				opts.Dim = n
+			case "M":
+				opts.M = n
+			case "EF_CONSTRUCTION":
+				opts.EfConstruction = n
+			case "EF_RUNTIME":
+				opts.EfRuntime = n
+			}
+		default:
+			return nil, errors.Errorf("ERR unknown vector field %s attribute %s", field, attr)
+		}
+	}
+
+	if opts.Type == "" || opts.Dim == 0 || opts.Metric == "" {
+		return nil, errors.Errorf("ERR vector field %s requires TYPE, DIM and DISTANCE_METRIC attributes", field)
+	}
+	return opts, nil
+}
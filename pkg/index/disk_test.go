@@ -0,0 +1,49 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRemoveReclaimedVersionsAfterCompaction(t *testing.T) {
+	s, err := storage.NewDisk(filepath.Join(t.TempDir(), "storage.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	idx := NewFTSIndex(s, []string{"doc:"}, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}})
+	idx.Load(nil)
+	s.OnSave(idx.Add)
+	// removals are delayed like the engine does, the log is compacted before they are done
+	reclaimed := make([]*storage.Document, 0)
+	s.OnDelete(func(d *storage.Document) {
+		reclaimed = append(reclaimed, d)
+	})
+
+	// unindexed blob makes the log large enough to be compacted
+	blob := []byte(strings.Repeat("x", 1<<20))
+	s.Begin(1)
+	for i := 0; i < 20; i++ {
+		s.Save("doc:1", storage.Hash{"title": []byte("term" + strconv.Itoa(i)), "blob": blob})
+	}
+	s.Save("doc:2", storage.Hash{"title": []byte("other")})
+	s.Commit()
+
+	if len(reclaimed) != 19 {
+		t.Fatalf("expected 19 reclaimed versions, got %d", len(reclaimed))
+	}
+	for _, d := range reclaimed {
+		idx.Remove(d)
+	}
+
+	if stats := idx.Stats(0); stats.Docs != 2 || stats.Terms != 2 {
+		t.Fatalf("expected 2 documents with 2 terms, got %d documents with %d terms", stats.Docs, stats.Terms)
+	}
+	assertDocs(t, idx.Read("term19", 1), 1, "doc:1")
+	assertDocs(t, idx.Read("term3", 1), 1)
+	assertDocs(t, idx.Read("other", 1), 1, "doc:2")
+}
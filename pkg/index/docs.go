@@ -0,0 +1,136 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sort"
+	"sync/atomic"
+)
+
+// docEntrySize is memory used by a reference to an indexed document version
+const docEntrySize = 8
+
+// docSet keeps indexed document versions sorted by key, versions of a key are adjacent. It is guarded by the index
+// lock. Like posting lists of the trie, the slice handed to a reader is never modified.
+type docSet struct {
+	docs   []*storage.Document
+	shared int32 // 1 if the slice could be held by a reader
+	size   int64
+}
+
+func newDocSet() *docSet {
+	return &docSet{}
+}
+
+// read returns the document versions marking them as shared, it is safe to call concurrently under the read lock
+func (d *docSet) read() []*storage.Document {
+	if atomic.LoadInt32(&d.shared) == 0 {
+		atomic.StoreInt32(&d.shared, 1)
+	}
+	return d.docs
+}
+
+// mutable returns the versions if they can be changed in place, otherwise a copy with the capacity for n more
+func (d *docSet) mutable(n int) []*storage.Document {
+	if atomic.LoadInt32(&d.shared) == 0 {
+		return d.docs
+	}
+	docs := make([]*storage.Document, len(d.docs), len(d.docs)+n)
+	copy(docs, d.docs)
+	atomic.StoreInt32(&d.shared, 0)
+	return docs
+}
+
+func (d *docSet) add(doc *storage.Document) {
+	idx := sort.Search(len(d.docs), func(i int) bool {
+		return d.docs[i].Key > doc.Key
+	})
+	docs := append(d.mutable(len(d.docs)/4+1), nil)
+	copy(docs[idx+1:], docs[idx:])
+	docs[idx] = doc
+	d.set(docs)
+}
+
+// addAll merges the batch of document versions into the set
+func (d *docSet) addAll(batch []*storage.Document) {
+	sorted := make([]*storage.Document, len(batch))
+	copy(sorted, batch)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].Key < sorted[b].Key
+	})
+	merged := make([]*storage.Document, 0, len(d.docs)+len(sorted))
+	i, j := 0, 0
+	for i < len(d.docs) && j < len(sorted) {
+		if d.docs[i].Key <= sorted[j].Key {
+			merged = append(merged, d.docs[i])
+			i++
+		} else {
+			merged = append(merged, sorted[j])
+			j++
+		}
+	}
+	merged = append(merged, d.docs[i:]...)
+	d.set(append(merged, sorted[j:]...))
+	atomic.StoreInt32(&d.shared, 0)
+}
+
+// remove returns false if the document version was not indexed
+func (d *docSet) remove(doc *storage.Document) bool {
+	idx := sort.Search(len(d.docs), func(i int) bool {
+		return d.docs[i].Key >= doc.Key
+	})
+	for ; idx < len(d.docs) && d.docs[idx].Key == doc.Key; idx++ {
+		if d.docs[idx] != doc {
+			continue
+		}
+		docs := d.mutable(0)
+		copy(docs[idx:], docs[idx+1:])
+		docs[len(docs)-1] = nil
+		d.set(docs[:len(docs)-1])
+		return true
+	}
+	return false
+}
+
+// contains reports whether the document version is indexed, it should be called by the writer
+func (d *docSet) contains(doc *storage.Document) bool {
+	idx := sort.Search(len(d.docs), func(i int) bool {
+		return d.docs[i].Key >= doc.Key
+	})
+	for ; idx < len(d.docs) && d.docs[idx].Key == doc.Key; idx++ {
+		if d.docs[idx] == doc {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *docSet) set(docs []*storage.Document) {
+	d.docs = docs
+	atomic.StoreInt64(&d.size, int64(cap(docs))*docEntrySize)
+}
+
+func (d *docSet) memoryUsage() int64 {
+	return atomic.LoadInt64(&d.size)
+}
+
+// AllIterator iterates all documents of the index visible at the snapshot. The indexed versions are taken
+// on the first call of Next, so the iterator is cheap to create and drop unread, and are filtered without the lock.
+type AllIterator struct {
+	i        *FTSIndex
+	snapshot uint64
+	docs     *DocsIterator
+}
+
+func (a *AllIterator) Next() (occurrence DocTermOccurrence, score float32, ok bool) {
+	if a.docs == nil {
+		a.i.mu.RLock()
+		a.docs = &DocsIterator{docs: a.i.docs.read(), snapshot: a.snapshot}
+		a.i.mu.RUnlock()
+	}
+	return a.docs.Next()
+}
+
+// ReadAll returns iterator over all document versions of the index visible at the snapshot offset
+func (i *FTSIndex) ReadAll(snapshot uint64) TermIterator {
+	return &AllIterator{i: i, snapshot: snapshot}
+}
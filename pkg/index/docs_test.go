@@ -0,0 +1,89 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"testing"
+)
+
+func TestReadAllSeesSnapshotVersions(t *testing.T) {
+	s := storage.NewMemory()
+	idx := NewFTSIndex(s, []string{"doc:"}, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}})
+	idx.Load(nil)
+	s.OnSave(idx.Add)
+	s.OnDelete(idx.Remove)
+
+	s.Begin(1)
+	s.Save("doc:2", storage.Hash{"title": []byte("two")})
+	s.Save("doc:1", storage.Hash{"title": []byte("one")})
+	s.Save("other:1", storage.Hash{"title": []byte("skipped")})
+	s.Commit()
+	before := s.Snapshot()
+	all := idx.ReadAll(before.Offset)
+	// the indexed versions are taken on the first read
+	first, _, _ := all.Next()
+
+	s.Begin(2)
+	s.Save("doc:1", storage.Hash{"title": []byte("one again")})
+	s.Save("doc:3", storage.Hash{"title": []byte("three")})
+	s.Delete("doc:2")
+	s.Commit()
+	after := s.Snapshot()
+	defer after.Release()
+
+	if first.Doc.Key != "doc:1" {
+		t.Fatalf("expected doc:1 first, got %s", first.Doc.Key)
+	}
+	assertDocs(t, all, 1, "doc:2")
+	assertDocs(t, idx.ReadAll(before.Offset), 1, "doc:1", "doc:2")
+	assertDocs(t, idx.ReadAll(after.Offset), 2, "doc:1", "doc:3")
+
+	// superseded versions are reclaimed with the last snapshot seeing them
+	before.Release()
+	assertDocs(t, idx.ReadAll(after.Offset), 2, "doc:1", "doc:3")
+	if count := idx.Stats(0).Docs; count != 2 {
+		t.Errorf("expected 2 indexed versions after reclaiming, got %d", count)
+	}
+}
+
+func assertDocs(t *testing.T, iter TermIterator, version uint64, keys ...string) {
+	t.Helper()
+	actual := make([]string, 0)
+	for {
+		occ, _, ok := iter.Next()
+		if !ok {
+			break
+		}
+		actual = append(actual, occ.Doc.Key)
+		if occ.Doc.Key == "doc:1" && occ.Doc.Version != version {
+			t.Errorf("expected doc:1 version %d, got %d", version, occ.Doc.Version)
+		}
+	}
+	if len(actual) != len(keys) {
+		t.Fatalf("expected documents %v, got %v", keys, actual)
+	}
+	for i := range keys {
+		if actual[i] != keys[i] {
+			t.Fatalf("expected documents %v, got %v", keys, actual)
+		}
+	}
+}
+
+func TestLoadSkipsQueuedDocumentsAlreadyLoaded(t *testing.T) {
+	s := storage.NewMemory()
+	idx := NewFTSIndex(s, []string{"doc:"}, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}})
+	s.OnSave(idx.Add)
+
+	// documents saved while the index is being created are queued and can be read by the loader as well
+	s.Begin(1)
+	s.Save("doc:1", storage.Hash{"title": []byte("hello")})
+	s.Save("doc:2", storage.Hash{"title": []byte("hello")})
+	s.Commit()
+	idx.Load(s.GetAll([]string{"doc:"}))
+
+	assertDocs(t, idx.Read("hello", 1), 1, "doc:1", "doc:2")
+	assertDocs(t, idx.ReadAll(1), 1, "doc:1", "doc:2")
+	if count := idx.Stats(0).Docs; count != 2 {
+		t.Errorf("expected 2 indexed versions, got %d", count)
+	}
+}
@@ -0,0 +1,41 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sort"
+)
+
+// fieldIndex indexes values of a single non-text field. It is changed only by the index writer,
+// implementations synchronize their readers themselves.
+type fieldIndex interface {
+	add(doc *storage.Document, value []byte)
+	remove(doc *storage.Document, value []byte)
+	memoryUsage() int64
+}
+
+// DocsIterator iterates documents sorted by key without scoring them, it is used for filters
+type DocsIterator struct {
+	docs     []*storage.Document
+	snapshot uint64
+	pos      int
+}
+
+// NewDocsIterator creates iterator over document versions visible at the snapshot, docs are sorted in place
+func NewDocsIterator(docs []*storage.Document, snapshot uint64) *DocsIterator {
+	sort.Slice(docs, func(a, b int) bool {
+		return docs[a].Key < docs[b].Key
+	})
+	return &DocsIterator{docs: docs, snapshot: snapshot}
+}
+
+func (d *DocsIterator) Next() (occurrence DocTermOccurrence, score float32, ok bool) {
+	for d.pos < len(d.docs) {
+		doc := d.docs[d.pos]
+		d.pos++
+		if doc.VisibleAt(d.snapshot) {
+			return DocTermOccurrence{Doc: doc}, 0, true
+		}
+	}
+	ok = false
+	return
+}
@@ -7,30 +7,64 @@ import (
 	"github.com/blevesearch/segment"
 	"github.com/emirpasic/gods/queues"
 	"github.com/emirpasic/gods/queues/arrayqueue"
-	"github.com/emirpasic/gods/sets/hashset"
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
 	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"math"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"unsafe"
 )
 
 var stopWords = []string{"a", "an", "and", "are", "as", "at",
 	"be", "but", "by", "for", "if", "in", "into", "is", "it", "no", "not", "of", "on", "or", "such",
 	"that", "the", "their", "then", "there", "these", "they", "this", "to", "was", "will", "with"}
 
+// loadBatchSize is the number of documents whose occurrences are merged into posting lists at once
+// during the initial index load, so that copy-on-write posting lists are not copied for every document
+const loadBatchSize = 1024
+
+// termOverhead approximates memory used by a trie node, a df map entry and a ranking tree node of a term
+const termOverhead = 160
+
+// FTSIndex is a full-text index over documents matching its prefixes.
+//
+// Concurrency model: writes are serialized by wmu, so there is a single writer at a time - either the loader
+// processing existing documents, the replication stream adding new document versions or the garbage collector
+// removing reclaimed ones. While the index is being created new documents are only queued by the replication
+// stream and are processed by the loader when the existing ones are done.
+// Posting lists are copy-on-write: a slice handed to a reader is never modified, the writer replaces it with a new one
+// under mu. Readers take mu only to look up a posting list and iterate it without any locks, so queries
+// neither block nor are blocked by long writes. Document versions that are superseded while waiting in the queue
+// are skipped, visibility of the indexed versions is decided by the query snapshot.
 type FTSIndex struct {
-	deleted     bool
-	prefixes    []string
-	fields      []string // sorted array
-	trie        Trier
-	df          map[string]uint
-	docsCount   int32
-	creating    bool
-	pendingDocs queues.Queue // TODO: 07/05/2023 handle document deletion, probably by marking document in the queue as deleted
-	mu          sync.RWMutex
+	s             storage.Storage
+	deleted       int32
+	prefixes      []string
+	fields        []string // sorted array of text fields
+	schema        []idxmodel.Field
+	fieldIndexes  map[string]fieldIndex
+	trie          Trier
+	df            map[string]uint
+	ranking       *redblacktree.Tree // TermStats -> nil, terms by document frequency for the stats
+	terms         int64
+	docsCount     int32
+	postings      int64
+	postingBytes  int64
+	positionBytes int64
+	forms         *wordForms
+	suffixFields  *bitset.BitSet // text fields with the suffix trie
+	suffixes      *suffixTrie    // nil if no field has the suffix trie
+	docs          *docSet
+	sortKeys      *sortKeys // nil if no field is sortable
+	creating      bool
+	pendingDocs   queues.Queue
+	mu            sync.RWMutex
+	wmu           sync.Mutex
 }
 
 type DocTermOccurrence struct {
@@ -38,6 +72,19 @@ type DocTermOccurrence struct {
 	TF          float32
 	Fields      bitset.BitSet
 	Occurrences []FieldTermOccurrence
+	// Computed are values calculated by the query and returned with the document, e.g. vector distances
+	Computed storage.Hash
+	// Explanation of the score, it is filled only when the query explains scores
+	Explanation *Explanation
+	// SortKey of the field the results are sorted by, nil if the document has no value of the field
+	SortKey *SortKey
+}
+
+// Explanation describes how a score was computed from the scores of the children
+type Explanation struct {
+	Score       float32
+	Description string
+	Children    []*Explanation
 }
 
 type FieldTermOccurrence struct {
@@ -66,44 +113,99 @@ func Empty() TermIterator {
 	return EmptyIterator{}
 }
 
-func NewFTSIndex(prefixes []string, fields []string) *FTSIndex {
+func NewFTSIndex(s storage.Storage, prefixes []string, schema []idxmodel.Field) *FTSIndex {
+	fields := make([]string, 0, len(schema))
+	fieldIndexes := make(map[string]fieldIndex)
+	for _, f := range schema {
+		switch f.Type {
+		case idxmodel.TypeNumeric:
+			fieldIndexes[f.Name] = newNumericIndex()
+		case idxmodel.TypeVector:
+			fieldIndexes[f.Name] = newVectorIndex(*f.Vector)
+		case idxmodel.TypeGeo:
+			fieldIndexes[f.Name] = newGeoIndex()
+		case idxmodel.TypeTag:
+			separator := f.Separator
+			if separator == "" {
+				separator = idxmodel.DefaultSeparator
+			}
+			fieldIndexes[f.Name] = newTagIndex(separator, f.CaseSensitive)
+		default:
+			fields = append(fields, f.Name)
+		}
+	}
 	sort.Strings(fields)
-	fieldSet := hashset.New()
-	for _, field := range fields {
-		fieldSet.Add(field)
+
+	suffixFields := bitset.New(uint(len(fields)))
+	var suffixes *suffixTrie
+	for _, f := range schema {
+		if f.Type == idxmodel.TypeText && f.WithSuffixTrie {
+			suffixFields.Set(uint(sort.SearchStrings(fields, f.Name)))
+			suffixes = newSuffixTrie()
+		}
 	}
+
 	return &FTSIndex{
-		prefixes:    prefixes,
-		fields:      fields,
-		trie:        NewRuneTrie(),
-		df:          map[string]uint{},
-		creating:    true,
-		pendingDocs: arrayqueue.New(),
-		docsCount:   0,
+		s:            s,
+		prefixes:     prefixes,
+		fields:       fields,
+		schema:       schema,
+		fieldIndexes: fieldIndexes,
+		trie:         NewRuneTrie(),
+		df:           map[string]uint{},
+		ranking:      newTermRanking(),
+		forms:        newWordForms(),
+		suffixFields: suffixFields,
+		suffixes:     suffixes,
+		docs:         newDocSet(),
+		sortKeys:     newSortKeys(schema),
+		creating:     true,
+		pendingDocs:  arrayqueue.New(),
+		docsCount:    0,
 	}
 }
 
 func (i *FTSIndex) Load(docs []*storage.Document) {
+	batch := make(map[string][]DocTermOccurrence)
+	batchDocs := make([]*storage.Document, 0, loadBatchSize)
+	batchHashes := make([]storage.Hash, 0, loadBatchSize)
+	batchWords := make([]docWords, 0, loadBatchSize)
 	for _, doc := range docs {
-		if i.deleted {
+		if i.isDeleted() {
 			return
 		}
 		if !matchesPrefix(i.prefixes, doc.Key) {
 			continue
 		}
-		i.processDoc(doc)
-	}
-	i.drainQueue()
 
-	i.creating = false
+		hash, err := i.s.Load(doc)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to load document %s, skipping", doc.Key)
+			continue
+		}
+		occurrences, words := i.analyze(doc, hash)
+		for term, occurrence := range occurrences {
+			batch[term] = append(batch[term], *occurrence)
+		}
+		batchDocs = append(batchDocs, doc)
+		batchHashes = append(batchHashes, hash)
+		batchWords = append(batchWords, words)
+
+		if len(batchDocs) == loadBatchSize {
+			i.applyBatch(batch, batchDocs, batchHashes, batchWords)
+			batch = make(map[string][]DocTermOccurrence)
+			batchDocs = batchDocs[:0]
+			batchHashes = batchHashes[:0]
+			batchWords = batchWords[:0]
+		}
+	}
+	i.applyBatch(batch, batchDocs, batchHashes, batchWords)
 
-	// draining the queue here because new docs could be added before flipping i.creating but after first drain
-	i.drainQueue()
-}
+	i.wmu.Lock()
+	defer i.wmu.Unlock()
 
-func (i *FTSIndex) drainQueue() {
 	for {
-		if i.deleted {
+		if i.isDeleted() {
 			return
 		}
 
@@ -111,12 +213,62 @@ func (i *FTSIndex) drainQueue() {
 		if !ok {
 			break
 		}
+		// the loader could read the queued version from storage as well
+		if i.docs.contains(doc.(*storage.Document)) {
+			continue
+		}
 		i.processDoc(doc.(*storage.Document))
 	}
+
+	i.creating = false
+}
+
+func (i *FTSIndex) applyBatch(batch map[string][]DocTermOccurrence, docs []*storage.Document,
+	hashes []storage.Hash, words []docWords) {
+	i.wmu.Lock()
+	defer i.wmu.Unlock()
+
+	// versions superseded during the batch processing could be already reclaimed, so they must not be added
+	added := make([]int, 0, len(docs))
+	for idx, doc := range docs {
+		if !doc.Superseded() {
+			added = append(added, idx)
+			i.addFields(doc, hashes[idx])
+			continue
+		}
+		for term, occurrences := range batch {
+			batch[term] = removeDoc(occurrences, doc)
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for term, occurrences := range batch {
+		if len(occurrences) == 0 {
+			continue
+		}
+		sort.Slice(occurrences, func(a, b int) bool {
+			return occurrences[a].Doc.Key < occurrences[b].Doc.Key
+		})
+		i.trie.Merge(term, occurrences)
+		i.addTerm(term, occurrences...)
+	}
+	addedDocs := make([]*storage.Document, 0, len(added))
+	for _, idx := range added {
+		i.addWords(words[idx])
+		addedDocs = append(addedDocs, docs[idx])
+	}
+	i.docs.addAll(addedDocs)
+	atomic.AddInt32(&i.docsCount, int32(len(added)))
 }
 
 func (i *FTSIndex) MarkDeleted() {
-	i.deleted = true
+	atomic.StoreInt32(&i.deleted, 1)
+}
+
+func (i *FTSIndex) isDeleted() bool {
+	return atomic.LoadInt32(&i.deleted) == 1
 }
 
 func (i *FTSIndex) Add(doc *storage.Document) {
@@ -124,26 +276,212 @@ func (i *FTSIndex) Add(doc *storage.Document) {
 		return
 	}
 
+	i.wmu.Lock()
+	defer i.wmu.Unlock()
+
 	// defer document indexing if not all existing docs are processed yet
 	if i.creating {
 		log.Debugf("Index is processing existing data, adding document %s to the queue", doc.Key)
 		i.pendingDocs.Enqueue(doc)
 		return
 	}
+
+	i.processDoc(doc)
 }
 
 func (i *FTSIndex) processDoc(doc *storage.Document) {
 	log.Debugf("Adding document %s to index", doc.Key)
 
+	hash, err := i.s.Load(doc)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to load document %s, skipping", doc.Key)
+		return
+	}
+
+	// the version could be superseded and even reclaimed while waiting in the queue,
+	// its removal cannot happen after this check as the writer lock is held
+	if doc.Superseded() {
+		return
+	}
+
+	occurrences, words := i.analyze(doc, hash)
+	i.addFields(doc, hash)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	atomic.AddInt32(&i.docsCount, 1)
+	i.docs.add(doc)
+	i.addWords(words)
+	for term, occurrence := range occurrences {
+		i.trie.Add(term, *occurrence)
+		i.addTerm(term, *occurrence)
+	}
+}
+
+// Remove drops the reclaimed document version from the index
+func (i *FTSIndex) Remove(doc *storage.Document) {
+	if !matchesPrefix(i.prefixes, doc.Key) {
+		return
+	}
+
+	hash, err := i.s.Load(doc)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to load document %s, cannot remove it from index", doc.Key)
+		return
+	}
+
+	occurrences, words := i.analyze(doc, hash)
+
+	i.wmu.Lock()
+	defer i.wmu.Unlock()
+
+	for field, fi := range i.fieldIndexes {
+		if value, ok := hash[field]; ok {
+			fi.remove(doc, value)
+		}
+	}
+	i.sortKeys.remove(doc)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	removed := false
+	for term, occurrence := range occurrences {
+		if !i.trie.Remove(term, doc) {
+			continue
+		}
+		removed = true
+		i.removeTerm(term, *occurrence)
+	}
+	if removed {
+		i.removeWords(words)
+	}
+	if i.docs.remove(doc) {
+		atomic.AddInt32(&i.docsCount, -1)
+	}
+}
+
+// addFields adds values of the non-text fields to their indexes and keeps the sort keys,
+// should be called under the writer lock
+func (i *FTSIndex) addFields(doc *storage.Document, hash storage.Hash) {
+	for field, fi := range i.fieldIndexes {
+		if value, ok := hash[field]; ok {
+			fi.add(doc, value)
+		}
+	}
+	i.sortKeys.add(doc, hash)
+}
+
+// addTerm accounts occurrences of the term added to the index, should be called under the write lock
+func (i *FTSIndex) addTerm(term string, occurrences ...DocTermOccurrence) {
+	postingBytes, positionBytes := int64(0), int64(0)
+	for _, o := range occurrences {
+		postingBytes += postingSize(o)
+		positionBytes += positionsSize(o)
+	}
+	df, ok := i.df[term]
+	if ok {
+		i.ranking.Remove(TermStats{Term: term, Postings: int(df)})
+	} else {
+		postingBytes += termOverhead + int64(len(term))
+		atomic.AddInt64(&i.terms, 1)
+	}
+	i.df[term] = df + uint(len(occurrences))
+	i.ranking.Put(TermStats{Term: term, Postings: int(i.df[term])}, nil)
+	atomic.AddInt64(&i.postings, int64(len(occurrences)))
+	atomic.AddInt64(&i.postingBytes, postingBytes)
+	atomic.AddInt64(&i.positionBytes, positionBytes)
+}
+
+// removeTerm accounts an occurrence of the term removed from the index, should be called under the write lock
+func (i *FTSIndex) removeTerm(term string, occurrence DocTermOccurrence) {
+	postingBytes := postingSize(occurrence)
+	df := i.df[term]
+	i.ranking.Remove(TermStats{Term: term, Postings: int(df)})
+	if df <= 1 {
+		delete(i.df, term)
+		postingBytes += termOverhead + int64(len(term))
+		atomic.AddInt64(&i.terms, -1)
+	} else {
+		i.df[term]--
+		i.ranking.Put(TermStats{Term: term, Postings: int(df - 1)}, nil)
+	}
+	atomic.AddInt64(&i.postings, -1)
+	atomic.AddInt64(&i.postingBytes, -postingBytes)
+	atomic.AddInt64(&i.positionBytes, -positionsSize(occurrence))
+}
+
+// MemoryUsage returns estimated memory used by the index
+func (i *FTSIndex) MemoryUsage() int64 {
+	usage := atomic.LoadInt64(&i.postingBytes) + atomic.LoadInt64(&i.positionBytes) +
+		i.forms.memoryUsage() + i.suffixes.memoryUsage() + i.docs.memoryUsage() +
+		i.sortKeys.memoryUsage()
+	for _, fi := range i.fieldIndexes {
+		usage += fi.memoryUsage()
+	}
+	return usage
+}
+
+// Covers reports whether documents with the given key are indexed
+func (i *FTSIndex) Covers(key string) bool {
+	return matchesPrefix(i.prefixes, key)
+}
+
+func (i *FTSIndex) Prefixes() []string {
+	return i.prefixes
+}
+
+// Fields returns sorted names of the indexed fields of all types
+func (i *FTSIndex) Fields() []string {
+	fields := make([]string, 0, len(i.schema))
+	for _, f := range i.schema {
+		fields = append(fields, f.Name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// TextFieldNames returns sorted names of the text fields, FieldTermOccurrence.FieldIdx is the position of the name
+func (i *FTSIndex) TextFieldNames() []string {
+	return i.fields
+}
+
+// TextFields returns set of indexes of the text fields as they are referenced by FieldTermOccurrence.FieldIdx
+func (i *FTSIndex) TextFields(names []string) (*bitset.BitSet, error) {
+	fields := bitset.New(uint(len(i.fields)))
+	for _, name := range names {
+		idx := sort.SearchStrings(i.fields, name)
+		if idx >= len(i.fields) || i.fields[idx] != name {
+			return nil, i.fieldError(name, idxmodel.TypeText)
+		}
+		fields.Set(uint(idx))
+	}
+	return fields, nil
+}
+
+// fieldError describes why the field cannot be queried as a field of the type
+func (i *FTSIndex) fieldError(field string, fieldType string) error {
+	for _, f := range i.schema {
+		if f.Name == field {
+			return errors.Errorf("Field `%s` is not a %s field", field, fieldType)
+		}
+	}
+	return errors.Errorf("Unknown field `%s`", field)
+}
+
+// analyze returns occurrences of the document terms and the words whose stems differ from them
+func (i *FTSIndex) analyze(doc *storage.Document, hash storage.Hash) (map[string]*DocTermOccurrence, docWords) {
 	// O(1) access to occurrence for current document, using trie here seems inefficient due to O(k) access and result as array of Occurrences in all documents
 	occurrences := make(map[string]*DocTermOccurrence)
+	forms := make(map[string]string)
 
 	termCount := 0
 
 	// token index counted across all fields
 	pos := 0
 
-	for k, v := range doc.Hash {
+	for k, v := range hash {
 		fieldIdx := sort.SearchStrings(i.fields, k)
 		if fieldIdx >= len(i.fields) || k != i.fields[fieldIdx] {
 			continue
@@ -160,7 +498,7 @@ func (i *FTSIndex) processDoc(doc *storage.Document) {
 				continue
 			}
 
-			i.processToken(doc, occurrences, fieldIdx, token, start, pos)
+			i.processToken(doc, occurrences, forms, fieldIdx, token, start, pos)
 
 			start = end
 			pos++
@@ -172,23 +510,58 @@ func (i *FTSIndex) processDoc(doc *storage.Document) {
 		termCount += pos
 	}
 
-	i.mu.Lock()
-	defer i.mu.Unlock()
+	for _, occurrence := range occurrences {
+		occurrence.TF = float32(len(occurrence.Occurrences)) / float32(termCount)
+	}
+	return occurrences, docWords{forms: forms, suffixes: i.suffixWords(occurrences, forms)}
+}
 
-	atomic.AddInt32(&i.docsCount, 1)
+// docWords are words of a document kept besides its terms
+type docWords struct {
+	forms    map[string]string // words differing from their stems
+	suffixes map[string]string // words of the fields with the suffix trie mapped to their terms
+}
+
+// suffixWords returns words of the document to add to the suffix trie, these are terms occurring in the fields
+// with the suffix trie and their forms
+func (i *FTSIndex) suffixWords(occurrences map[string]*DocTermOccurrence, forms map[string]string) map[string]string {
+	if i.suffixes == nil {
+		return nil
+	}
+	words := make(map[string]string)
 	for term, occurrence := range occurrences {
-		occurrence.TF = float32(len(occurrence.Occurrences)) / float32(termCount)
-		i.trie.Add(term, *occurrence)
-		df, ok := i.df[term]
-		if !ok {
-			i.df[term] = 1
-		} else {
-			i.df[term] = df + 1
+		if occurrence.Fields.IntersectionCardinality(i.suffixFields) > 0 {
+			words[term] = term
+		}
+	}
+	for form, term := range forms {
+		if _, ok := words[term]; ok {
+			words[form] = term
 		}
 	}
+	return words
+}
+
+// addWords adds the words of a document, should be called under the write lock
+func (i *FTSIndex) addWords(words docWords) {
+	i.forms.add(words.forms)
+	if i.suffixes != nil {
+		i.suffixes.add(words.suffixes)
+	}
 }
 
-func (i *FTSIndex) processToken(doc *storage.Document, occurrences map[string]*DocTermOccurrence, fieldIdx int, token string, start int, pos int) {
+// removeWords removes the words of a document, should be called under the write lock
+func (i *FTSIndex) removeWords(words docWords) {
+	i.forms.remove(words.forms)
+	if i.suffixes != nil {
+		i.suffixes.remove(words.suffixes)
+	}
+}
+
+func (i *FTSIndex) processToken(doc *storage.Document, occurrences map[string]*DocTermOccurrence, forms map[string]string,
+	fieldIdx int, token string, start int, pos int) {
+	// lower casing can change the byte length, the occurrence spans the token of the field value
+	length := len(token)
 	token = strings.ToLower(token)
 
 	if isStopWord(token) {
@@ -197,6 +570,9 @@ func (i *FTSIndex) processToken(doc *storage.Document, occurrences map[string]*D
 
 	termRunes := porterstemmer.StemWithoutLowerCasing([]rune(token))
 	term := string(termRunes)
+	if term != token {
+		forms[token] = term
+	}
 
 	occurrence, found := occurrences[term]
 	if !found {
@@ -206,18 +582,24 @@ func (i *FTSIndex) processToken(doc *storage.Document, occurrences map[string]*D
 
 	occurrence.Fields.Set(uint(fieldIdx))
 
-	fieldOccurrence := FieldTermOccurrence{FieldIdx: fieldIdx, Offset: start, Len: len(token), Pos: pos}
+	fieldOccurrence := FieldTermOccurrence{FieldIdx: fieldIdx, Offset: start, Len: length, Pos: pos}
 	occurrence.Occurrences = append(occurrence.Occurrences, fieldOccurrence)
 }
 
 type readIterator struct {
 	i           *FTSIndex
+	snapshot    uint64
 	term        string
 	idf         float32
 	occurrences []DocTermOccurrence
 	pos         int
 }
 
+// Term returns the term the iterator reads occurrences of
+func (r *readIterator) Term() string {
+	return r.term
+}
+
 func (r *readIterator) Next() (occurrence DocTermOccurrence, score float32, ok bool) {
 	for {
 		if r.pos == len(r.occurrences) {
@@ -226,7 +608,7 @@ func (r *readIterator) Next() (occurrence DocTermOccurrence, score float32, ok b
 		}
 		occurrence = r.occurrences[r.pos]
 		r.pos++
-		if occurrence.Doc.Deleted {
+		if !occurrence.Doc.VisibleAt(r.snapshot) {
 			continue
 		}
 		return occurrence, occurrence.TF * r.idf, true
@@ -234,7 +616,9 @@ func (r *readIterator) Next() (occurrence DocTermOccurrence, score float32, ok b
 
 }
 
-func (i *FTSIndex) Read(term string) TermIterator {
+// Read returns iterator over occurrences of the word in document versions visible at the snapshot offset.
+// The word is stemmed the same way as document words are, so it should be passed as it is in the query.
+func (i *FTSIndex) Read(term string, snapshot uint64) TermIterator {
 	term = strings.ToLower(term)
 	if isStopWord(term) {
 		return StopWordIterator{}
@@ -250,7 +634,68 @@ func (i *FTSIndex) Read(term string) TermIterator {
 		return Empty()
 	}
 	idf := i.idf(term)
-	return &readIterator{i: i, term: term, idf: idf, occurrences: occurrences, pos: 0}
+	return &readIterator{i: i, snapshot: snapshot, term: term, idf: idf, occurrences: occurrences, pos: 0}
+}
+
+// ReadNumeric returns iterator over document versions visible at the snapshot offset
+// which have value of the numeric field in the range
+func (i *FTSIndex) ReadNumeric(field string, r NumericRange, snapshot uint64) (TermIterator, error) {
+	n, ok := i.fieldIndexes[field].(*numericIndex)
+	if !ok {
+		return nil, i.fieldError(field, idxmodel.TypeNumeric)
+	}
+	return NewDocsIterator(n.read(r), snapshot), nil
+}
+
+// ReadTags returns iterator over document versions visible at the snapshot offset
+// which have any of the tags in the tag field
+func (i *FTSIndex) ReadTags(field string, tags []string, snapshot uint64) (TermIterator, error) {
+	t, ok := i.fieldIndexes[field].(*tagIndex)
+	if !ok {
+		return nil, i.fieldError(field, idxmodel.TypeTag)
+	}
+	return NewDocsIterator(t.read(tags), snapshot), nil
+}
+
+// ReadGeo returns iterator over document versions visible at the snapshot offset
+// which have point of the geo field within the radius
+func (i *FTSIndex) ReadGeo(field string, r GeoRadius, snapshot uint64) (TermIterator, error) {
+	g, ok := i.fieldIndexes[field].(*geoIndex)
+	if !ok {
+		return nil, i.fieldError(field, idxmodel.TypeGeo)
+	}
+	return NewDocsIterator(g.read(r), snapshot), nil
+}
+
+// ReadKNN returns iterator over k document versions visible at the snapshot offset with the vectors nearest
+// to the query vector. If filter is not nil, only the documents it returns are considered.
+func (i *FTSIndex) ReadKNN(field string, q KNNQuery, filter TermIterator, snapshot uint64) (TermIterator, error) {
+	v, ok := i.fieldIndexes[field].(*vectorIndex)
+	if !ok {
+		return nil, i.fieldError(field, idxmodel.TypeVector)
+	}
+	vec, err := v.decode(q.Vector)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*storage.Document
+	if filter != nil {
+		candidates = make([]*storage.Document, 0)
+		for {
+			occurrence, _, ok := filter.Next()
+			if !ok {
+				break
+			}
+			candidates = append(candidates, occurrence.Doc)
+		}
+	}
+
+	results := v.search(vec, q.K, candidates, snapshot)
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].doc.Key < results[b].doc.Key
+	})
+	return &knnIterator{results: results, scoreField: q.ScoreField}, nil
 }
 
 func (i *FTSIndex) PrintIndex() {
@@ -260,10 +705,14 @@ func (i *FTSIndex) PrintIndex() {
 	_ = i.trie.Walk(func(key string, occurrences []DocTermOccurrence) error {
 		fmt.Printf("Term: %s, IDF = %.3f\n", key, i.idf(key))
 		for _, o := range occurrences {
+			hash, err := i.s.Load(o.Doc)
+			if err != nil {
+				return err
+			}
 			fmt.Printf("\tDocument %s Occurrences (%d, TF = %.3f):\n", o.Doc.Key, len(o.Occurrences), o.TF)
 			for _, fo := range o.Occurrences {
 				field := i.fields[fo.FieldIdx]
-				value := o.Doc.Hash[field]
+				value := hash[field]
 				word := string(value[fo.Offset : fo.Offset+fo.Len])
 				fmt.Printf("\t\t@%s (offset %d, len %d, pos %d): %s\n",
 					field, fo.Offset, fo.Len, fo.Pos, word)
@@ -279,6 +728,26 @@ func (i *FTSIndex) idf(term string) float32 {
 	return float32(idf)
 }
 
+// removeDoc returns occurrences without the ones of the given document version, the slice is modified in place
+func removeDoc(occurrences []DocTermOccurrence, doc *storage.Document) []DocTermOccurrence {
+	n := 0
+	for _, o := range occurrences {
+		if o.Doc != doc {
+			occurrences[n] = o
+			n++
+		}
+	}
+	return occurrences[:n]
+}
+
+func postingSize(o DocTermOccurrence) int64 {
+	return int64(unsafe.Sizeof(o)) + int64(len(o.Fields.Bytes()))*8
+}
+
+func positionsSize(o DocTermOccurrence) int64 {
+	return int64(len(o.Occurrences)) * int64(unsafe.Sizeof(FieldTermOccurrence{}))
+}
+
 func matchesPrefix(prefixes []string, key string) bool {
 	for _, prefix := range prefixes {
 		if prefix == "*" || strings.HasPrefix(key, prefix) {
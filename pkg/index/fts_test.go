@@ -0,0 +1,31 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sort"
+	"testing"
+)
+
+// newTestIndex creates index over all keys that is kept up to date with the returned storage,
+// the documents are saved at offset 1
+func newTestIndex(t *testing.T, schema []idxmodel.Field, docs map[string]storage.Hash) (*FTSIndex, *storage.MemoryStorage) {
+	t.Helper()
+	s := storage.NewMemory()
+	idx := NewFTSIndex(s, []string{""}, schema)
+	idx.Load(nil)
+	s.OnSave(idx.Add)
+	s.OnDelete(idx.Remove)
+
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s.Begin(1)
+	for _, key := range keys {
+		s.Save(key, docs[key])
+	}
+	s.Commit()
+	return idx, s
+}
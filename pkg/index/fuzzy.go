@@ -0,0 +1,68 @@
+package index
+
+import (
+	"github.com/blevesearch/go-porterstemmer"
+	"sort"
+	"strings"
+)
+
+// FuzzyPenalty is the share of the score an expanded term loses per edit
+const FuzzyPenalty = 0.25
+
+// ReadFuzzy returns iterators over occurrences of up to maxExpansions terms within the Levenshtein distance
+// of the word or its stem, the closest terms are preferred. Scores of the expanded terms are reduced
+// by FuzzyPenalty for each edit.
+func (i *FTSIndex) ReadFuzzy(word string, distance int, maxExpansions int, snapshot uint64) []TermIterator {
+	word = strings.ToLower(word)
+	words := []string{word}
+	if stem := string(porterstemmer.StemWithoutLowerCasing([]rune(word))); stem != word {
+		words = append(words, stem)
+	}
+
+	type match struct {
+		term        string
+		distance    int
+		occurrences []DocTermOccurrence
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	matches := make(map[string]*match)
+	for _, w := range words {
+		_ = i.trie.WalkFuzzy(w, distance, func(term string, occurrences []DocTermOccurrence, d int) error {
+			if m, found := matches[term]; found {
+				if d < m.distance {
+					m.distance = d
+				}
+				return nil
+			}
+			matches[term] = &match{term: term, distance: d, occurrences: occurrences}
+			return nil
+		})
+	}
+
+	sorted := make([]*match, 0, len(matches))
+	for _, m := range matches {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		if sorted[a].distance != sorted[b].distance {
+			return sorted[a].distance < sorted[b].distance
+		}
+		return sorted[a].term < sorted[b].term
+	})
+	if len(sorted) > maxExpansions {
+		sorted = sorted[:maxExpansions]
+	}
+
+	iters := make([]TermIterator, len(sorted))
+	for n, m := range sorted {
+		penalty := 1 - FuzzyPenalty*float32(m.distance)
+		if penalty < 0 {
+			penalty = 0
+		}
+		iters[n] = &readIterator{i: i, snapshot: snapshot, term: m.term, idf: i.idf(m.term) * penalty, occurrences: m.occurrences}
+	}
+	return iters
+}
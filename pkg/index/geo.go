@@ -0,0 +1,246 @@
+package index
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/emirpasic/gods/utils"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// geoSteps is the precision of geohash per coordinate, the same as in Redis GEO commands
+	geoSteps  = 26
+	geoLatMax = 85.05112878
+	geoLonMax = 180.0
+	// earthRadius in meters is the one used by Redis to calculate distances
+	earthRadius = 6372797.560856
+	// geoEntrySize approximates memory used by a tree node and a point entry
+	geoEntrySize = 96
+)
+
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.34,
+	"ft": 0.3048,
+}
+
+// GeoRadius selects points within Radius meters from the center
+type GeoRadius struct {
+	Lon    float64
+	Lat    float64
+	Radius float64
+}
+
+// ParseGeoRadius parses the center coordinates and the radius in one of m, km, mi or ft units
+func ParseGeoRadius(lon string, lat string, radius string, unit string) (GeoRadius, error) {
+	r := GeoRadius{}
+	var err error
+	if r.Lon, r.Lat, err = parseCoordinates(lon, lat); err != nil {
+		return r, err
+	}
+	if r.Radius, err = strconv.ParseFloat(radius, 64); err != nil || r.Radius < 0 {
+		return r, errors.Errorf("Bad radius %s", radius)
+	}
+	multiplier, ok := geoUnits[strings.ToLower(unit)]
+	if !ok {
+		return r, errors.Errorf("Bad distance unit %s, expected m, km, mi or ft", unit)
+	}
+	r.Radius *= multiplier
+	return r, nil
+}
+
+func parseCoordinates(lonStr string, latStr string) (lon float64, lat float64, err error) {
+	lon, err = strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil || lon < -geoLonMax || lon > geoLonMax {
+		return 0, 0, errors.Errorf("Bad longitude %s", lonStr)
+	}
+	lat, err = strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil || lat < -geoLatMax || lat > geoLatMax {
+		return 0, 0, errors.Errorf("Bad latitude %s", latStr)
+	}
+	return lon, lat, nil
+}
+
+type geoPoint struct {
+	doc *storage.Document
+	lon float64
+	lat float64
+}
+
+// geoIndex keeps points in a red-black tree ordered by geohash, so that every geohash cell is a continuous range.
+// Radius query scans the cell of the center and its neighbours at the precision where cells are not smaller
+// than the radius, and then filters points by the distance.
+type geoIndex struct {
+	tree *redblacktree.Tree // uint64 geohash -> []geoPoint
+	size int64
+	mu   sync.RWMutex
+}
+
+func newGeoIndex() *geoIndex {
+	return &geoIndex{tree: redblacktree.NewWith(utils.UInt64Comparator)}
+}
+
+// parsePoint parses the field value in "lon,lat" format
+func parsePoint(value []byte) (lon float64, lat float64, ok bool) {
+	parts := strings.Split(string(value), ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	lon, lat, err := parseCoordinates(parts[0], parts[1])
+	return lon, lat, err == nil
+}
+
+func (g *geoIndex) add(doc *storage.Document, value []byte) {
+	lon, lat, ok := parsePoint(value)
+	if !ok {
+		return
+	}
+	hash := geohash(lon, lat)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	points, _ := g.tree.Get(hash)
+	if points == nil {
+		g.tree.Put(hash, []geoPoint{{doc, lon, lat}})
+	} else {
+		g.tree.Put(hash, append(points.([]geoPoint), geoPoint{doc, lon, lat}))
+	}
+	atomic.AddInt64(&g.size, geoEntrySize)
+}
+
+func (g *geoIndex) remove(doc *storage.Document, value []byte) {
+	lon, lat, ok := parsePoint(value)
+	if !ok {
+		return
+	}
+	hash := geohash(lon, lat)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	found, _ := g.tree.Get(hash)
+	if found == nil {
+		return
+	}
+	points := found.([]geoPoint)
+	for idx, p := range points {
+		if p.doc != doc {
+			continue
+		}
+		if len(points) == 1 {
+			g.tree.Remove(hash)
+		} else {
+			g.tree.Put(hash, append(points[:idx:idx], points[idx+1:]...))
+		}
+		atomic.AddInt64(&g.size, -geoEntrySize)
+		return
+	}
+}
+
+func (g *geoIndex) memoryUsage() int64 {
+	return atomic.LoadInt64(&g.size)
+}
+
+func (g *geoIndex) read(r GeoRadius) []*storage.Document {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	result := make([]*storage.Document, 0)
+	collect := func(from uint64, to uint64) {
+		node, found := g.tree.Ceiling(from)
+		if !found {
+			return
+		}
+		it := g.tree.IteratorAt(node)
+		for ok := true; ok && it.Key().(uint64) < to; ok = it.Next() {
+			for _, p := range it.Value().([]geoPoint) {
+				if distance(r.Lon, r.Lat, p.lon, p.lat) <= r.Radius {
+					result = append(result, p.doc)
+				}
+			}
+		}
+	}
+
+	step := estimateStep(r)
+	if step == 0 {
+		collect(0, math.MaxUint64)
+		return result
+	}
+	n := int64(1) << step
+	x, y := cell(r.Lon, r.Lat, step)
+	shift := 2 * (geoSteps - step)
+	scanned := make(map[uint64]bool)
+	for dy := int64(-1); dy <= 1; dy++ {
+		if y+dy < 0 || y+dy >= n {
+			continue
+		}
+		for dx := int64(-1); dx <= 1; dx++ {
+			// longitude wraps around the antimeridian
+			c := interleave(uint64((x+dx+n)%n), uint64(y+dy))
+			if scanned[c] {
+				continue
+			}
+			scanned[c] = true
+			collect(c<<shift, (c+1)<<shift)
+		}
+	}
+	return result
+}
+
+// estimateStep returns the geohash precision at which a cell is not smaller than the radius, 0 means the whole world
+func estimateStep(r GeoRadius) uint {
+	if r.Radius <= 0 {
+		return geoSteps
+	}
+	// cells are the narrowest at the edge of the circle closest to the pole
+	farLat := math.Min(math.Abs(r.Lat)+r.Radius/earthRadius*180/math.Pi, 90)
+	lonSpan := 2 * math.Pi * earthRadius * math.Cos(farLat*math.Pi/180)
+	latSpan := earthRadius * 2 * geoLatMax * math.Pi / 180
+	span := math.Min(lonSpan, latSpan)
+	step := math.Floor(math.Log2(span / r.Radius))
+	if step < 2 {
+		return 0
+	}
+	if step > geoSteps {
+		return geoSteps
+	}
+	return uint(step)
+}
+
+func cell(lon float64, lat float64, step uint) (x int64, y int64) {
+	n := float64(int64(1) << step)
+	x = int64((lon + geoLonMax) / (2 * geoLonMax) * n)
+	y = int64((lat + geoLatMax) / (2 * geoLatMax) * n)
+	// the max coordinates belong to the last cell
+	if x >= int64(n) {
+		x = int64(n) - 1
+	}
+	if y >= int64(n) {
+		y = int64(n) - 1
+	}
+	return x, y
+}
+
+func geohash(lon float64, lat float64) uint64 {
+	x, y := cell(lon, lat, geoSteps)
+	return interleave(uint64(x), uint64(y))
+}
+
+// interleave mixes bits of the cell coordinates so that the hash prefix is the cell of a lower precision
+func interleave(x uint64, y uint64) uint64 {
+	var hash uint64
+	for bit := 31; bit >= 0; bit-- {
+		hash = hash<<2 | (x>>uint(bit)&1)<<1 | y>>uint(bit)&1
+	}
+	return hash
+}
+
+// distance returns the haversine distance in meters
+func distance(lon1 float64, lat1 float64, lon2 float64, lat2 float64) float64 {
+	lat1r, lat2r := lat1*math.Pi/180, lat2*math.Pi/180
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2 - lon1) * math.Pi / 180 / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
@@ -0,0 +1,95 @@
+package index
+
+import (
+	"fmt"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+var geoSchema = []idxmodel.Field{{Name: "location", Type: idxmodel.TypeGeo}}
+
+func readGeo(t *testing.T, idx *FTSIndex, lon float64, lat float64, radius float64) TermIterator {
+	t.Helper()
+	iter, err := idx.ReadGeo("location", GeoRadius{Lon: lon, Lat: lat, Radius: radius}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return iter
+}
+
+func TestReadGeoAcrossCellBoundaries(t *testing.T) {
+	idx, _ := newTestIndex(t, geoSchema, map[string]storage.Hash{
+		// lon 0 and lat 0 split the cells of every precision
+		"doc:1": {"location": []byte("-0.001,-0.001")},
+		"doc:2": {"location": []byte("0.001,0.001")},
+		"doc:3": {"location": []byte("-0.001,0.001")},
+		"doc:4": {"location": []byte("0.01,0")},
+		// both sides of the antimeridian
+		"doc:5": {"location": []byte("179.999,10")},
+		"doc:6": {"location": []byte("-179.999,10")},
+		"doc:7": {"location": []byte("not a point")},
+	})
+
+	assertDocs(t, readGeo(t, idx, 0, 0, 500), 1, "doc:1", "doc:2", "doc:3")
+	assertDocs(t, readGeo(t, idx, 0, 0, 1500), 1, "doc:1", "doc:2", "doc:3", "doc:4")
+	assertDocs(t, readGeo(t, idx, 0.0005, 0.0005, 100), 1, "doc:2")
+	assertDocs(t, readGeo(t, idx, 180, 10, 500), 1, "doc:5", "doc:6")
+	assertDocs(t, readGeo(t, idx, -179.9995, 10, 100), 1, "doc:6")
+	// the radius larger than the cells of any precision scans the whole world
+	assertDocs(t, readGeo(t, idx, 90, 0, 20_000_000), 1, "doc:1", "doc:2", "doc:3", "doc:4", "doc:5", "doc:6")
+}
+
+func TestReadGeoMatchesDistanceFilter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	docs := map[string]storage.Hash{}
+	points := map[string][2]float64{}
+	for i := 0; i < 1000; i++ {
+		key := "doc:" + strconv.Itoa(i)
+		lon, lat := 13.4+rnd.Float64()*0.2-0.1, 52.5+rnd.Float64()*0.2-0.1
+		points[key] = [2]float64{lon, lat}
+		docs[key] = storage.Hash{"location": []byte(fmt.Sprintf("%f,%f", lon, lat))}
+	}
+	idx, _ := newTestIndex(t, geoSchema, docs)
+
+	for _, radius := range []float64{10, 300, 1000, 5000, 20000} {
+		for i := 0; i < 10; i++ {
+			lon, lat := 13.4+rnd.Float64()*0.2-0.1, 52.5+rnd.Float64()*0.2-0.1
+			expected := make([]string, 0)
+			for key, p := range points {
+				// points are stored with the precision they are formatted with
+				plon, _ := strconv.ParseFloat(fmt.Sprintf("%f", p[0]), 64)
+				plat, _ := strconv.ParseFloat(fmt.Sprintf("%f", p[1]), 64)
+				if distance(lon, lat, plon, plat) <= radius {
+					expected = append(expected, key)
+				}
+			}
+			sort.Strings(expected)
+			assertDocs(t, readGeo(t, idx, lon, lat, radius), 1, expected...)
+		}
+	}
+}
+
+func TestParseGeoRadius(t *testing.T) {
+	for _, c := range []struct {
+		radius string
+		unit   string
+		meters float64
+	}{{"10", "m", 10}, {"1.5", "KM", 1500}, {"2", "mi", 3218.68}, {"100", "ft", 30.48}} {
+		r, err := ParseGeoRadius("13.4", "52.5", c.radius, c.unit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Lon != 13.4 || r.Lat != 52.5 || r.Radius != c.meters {
+			t.Errorf("expected %s %s to be %v m at 13.4,52.5, got %v", c.radius, c.unit, c.meters, r)
+		}
+	}
+	for _, args := range [][]string{{"181", "0", "1", "m"}, {"0", "86", "1", "m"}, {"0", "0", "-1", "m"}, {"0", "0", "1", "yd"}} {
+		if _, err := ParseGeoRadius(args[0], args[1], args[2], args[3]); err == nil {
+			t.Errorf("expected %v to be rejected", args)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package index
+
+import (
+	"github.com/emirpasic/gods/trees/binaryheap"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// default HNSW parameters are the same as in RediSearch
+const (
+	defaultM              = 16
+	defaultEfConstruction = 200
+	defaultEfRuntime      = 10
+)
+
+type hnswNode struct {
+	doc       *storage.Document
+	vec       []float32
+	neighbors [][]*hnswNode // per layer, from 0 to the node level
+	// referrers are nodes linked to this one per layer, links are not symmetric after neighbours are shrunk
+	referrers []map[*hnswNode]bool
+}
+
+func newHNSWNode(doc *storage.Document, vec []float32, level int) *hnswNode {
+	node := &hnswNode{doc: doc, vec: vec, neighbors: make([][]*hnswNode, level+1), referrers: make([]map[*hnswNode]bool, level+1)}
+	for i := range node.referrers {
+		node.referrers[i] = map[*hnswNode]bool{}
+	}
+	return node
+}
+
+func link(from *hnswNode, to *hnswNode, layer int) {
+	from.neighbors[layer] = append(from.neighbors[layer], to)
+	to.referrers[layer][from] = true
+}
+
+type hnswCandidate struct {
+	node     *hnswNode
+	distance float32
+}
+
+// hnswGraph is a hierarchical navigable small world graph for approximate nearest neighbours search.
+// It is changed only under the vector index write lock, searches run under the read lock.
+type hnswGraph struct {
+	m              int
+	m0             int
+	efConstruction int
+	efRuntime      int
+	levelMult      float64
+	distance       func(a []float32, b []float32) float32
+	nodes          map[*storage.Document]*hnswNode
+	entry          *hnswNode
+	rand           *rand.Rand
+}
+
+func newHNSWGraph(opts idxmodel.VectorOptions, distance func(a []float32, b []float32) float32) *hnswGraph {
+	g := &hnswGraph{
+		m:              opts.M,
+		efConstruction: opts.EfConstruction,
+		efRuntime:      opts.EfRuntime,
+		distance:       distance,
+		nodes:          map[*storage.Document]*hnswNode{},
+		rand:           rand.New(rand.NewSource(1)),
+	}
+	if g.m == 0 {
+		g.m = defaultM
+	}
+	if g.efConstruction == 0 {
+		g.efConstruction = defaultEfConstruction
+	}
+	if g.efRuntime == 0 {
+		g.efRuntime = defaultEfRuntime
+	}
+	g.m0 = 2 * g.m
+	g.levelMult = 1 / math.Log(float64(g.m))
+	return g
+}
+
+func (g *hnswGraph) maxNeighbors(layer int) int {
+	if layer == 0 {
+		return g.m0
+	}
+	return g.m
+}
+
+// insert adds the vector to the graph and returns approximate memory used by its links
+func (g *hnswGraph) insert(doc *storage.Document, vec []float32) int64 {
+	level := int(math.Floor(-math.Log(1-g.rand.Float64()) * g.levelMult))
+	node := newHNSWNode(doc, vec, level)
+	g.nodes[doc] = node
+	if g.entry == nil {
+		g.entry = node
+		return g.linksSize(node)
+	}
+
+	entry := g.entry
+	top := len(entry.neighbors) - 1
+	for layer := top; layer > level; layer-- {
+		entry = g.greedy(entry, vec, layer)
+	}
+	layer := level
+	if layer > top {
+		layer = top
+	}
+	for ; layer >= 0; layer-- {
+		candidates := g.searchLayer(vec, entry, g.efConstruction, layer, nil)
+		for i, c := range candidates {
+			if i == g.m {
+				break
+			}
+			link(node, c.node, layer)
+			link(c.node, node, layer)
+			if len(c.node.neighbors[layer]) > g.maxNeighbors(layer) {
+				g.shrink(c.node, layer)
+			}
+		}
+		entry = candidates[0].node
+	}
+	if level > top {
+		g.entry = node
+	}
+	return g.linksSize(node)
+}
+
+// remove drops the node and links its neighbours with each other in place of the dropped links
+func (g *hnswGraph) remove(doc *storage.Document) int64 {
+	node, ok := g.nodes[doc]
+	if !ok {
+		return 0
+	}
+	delete(g.nodes, doc)
+
+	for layer, neighbors := range node.neighbors {
+		for _, n := range neighbors {
+			delete(n.referrers[layer], node)
+		}
+		for r := range node.referrers[layer] {
+			r.neighbors[layer] = removeNode(r.neighbors[layer], node)
+			for _, candidate := range neighbors {
+				if len(r.neighbors[layer]) >= g.maxNeighbors(layer) {
+					break
+				}
+				if candidate != r && !containsNode(r.neighbors[layer], candidate) {
+					link(r, candidate, layer)
+				}
+			}
+		}
+	}
+
+	if g.entry == node {
+		g.entry = nil
+		for _, n := range g.nodes {
+			if g.entry == nil || len(n.neighbors) > len(g.entry.neighbors) {
+				g.entry = n
+			}
+		}
+	}
+	return g.linksSize(node)
+}
+
+func (g *hnswGraph) linksSize(node *hnswNode) int64 {
+	return int64(g.m0+g.m*(len(node.neighbors)-1)) * 8
+}
+
+// shrink keeps only the closest neighbours of the node on the layer
+func (g *hnswGraph) shrink(node *hnswNode, layer int) {
+	neighbors := node.neighbors[layer]
+	candidates := make([]hnswCandidate, len(neighbors))
+	for i, n := range neighbors {
+		candidates[i] = hnswCandidate{node: n, distance: g.distance(node.vec, n.vec)}
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].distance < candidates[b].distance
+	})
+	node.neighbors[layer] = make([]*hnswNode, 0, g.maxNeighbors(layer))
+	for i, c := range candidates {
+		if i < g.maxNeighbors(layer) {
+			node.neighbors[layer] = append(node.neighbors[layer], c.node)
+		} else {
+			delete(c.node.referrers[layer], node)
+		}
+	}
+}
+
+func (g *hnswGraph) greedy(entry *hnswNode, vec []float32, layer int) *hnswNode {
+	current, distance := entry, g.distance(vec, entry.vec)
+	for changed := true; changed; {
+		changed = false
+		for _, n := range current.neighbors[layer] {
+			if d := g.distance(vec, n.vec); d < distance {
+				current, distance, changed = n, d, true
+			}
+		}
+	}
+	return current
+}
+
+// searchLayer returns up to ef nodes closest to the vector sorted by distance.
+// Nodes not matching the filter are traversed, but not returned.
+func (g *hnswGraph) searchLayer(vec []float32, entry *hnswNode, ef int, layer int, filter func(*storage.Document) bool) []hnswCandidate {
+	closest := binaryheap.NewWith(func(a, b interface{}) int {
+		return compareDistance(a.(hnswCandidate).distance, b.(hnswCandidate).distance)
+	})
+	farthest := binaryheap.NewWith(func(a, b interface{}) int {
+		return compareDistance(b.(hnswCandidate).distance, a.(hnswCandidate).distance)
+	})
+	visited := map[*hnswNode]bool{entry: true}
+
+	start := hnswCandidate{node: entry, distance: g.distance(vec, entry.vec)}
+	closest.Push(start)
+	if filter == nil || filter(entry.doc) {
+		farthest.Push(start)
+	}
+
+	for {
+		c, ok := closest.Pop()
+		if !ok {
+			break
+		}
+		candidate := c.(hnswCandidate)
+		if worst, ok := farthest.Peek(); ok && farthest.Size() >= ef && candidate.distance > worst.(hnswCandidate).distance {
+			break
+		}
+		for _, n := range candidate.node.neighbors[layer] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+			next := hnswCandidate{node: n, distance: g.distance(vec, n.vec)}
+			worst, ok := farthest.Peek()
+			if farthest.Size() < ef || ok && next.distance < worst.(hnswCandidate).distance {
+				closest.Push(next)
+				if filter != nil && !filter(n.doc) {
+					continue
+				}
+				farthest.Push(next)
+				if farthest.Size() > ef {
+					farthest.Pop()
+				}
+			}
+		}
+	}
+
+	result := make([]hnswCandidate, farthest.Size())
+	for i := len(result) - 1; i >= 0; i-- {
+		c, _ := farthest.Pop()
+		result[i] = c.(hnswCandidate)
+	}
+	return result
+}
+
+// search returns k nearest documents matching the filter, the search is repeated with a wider beam
+// if the filter rejects too many nodes
+func (g *hnswGraph) search(vec []float32, k int, filter func(*storage.Document) bool) []vectorResult {
+	if g.entry == nil {
+		return []vectorResult{}
+	}
+	entry := g.entry
+	for layer := len(entry.neighbors) - 1; layer > 0; layer-- {
+		entry = g.greedy(entry, vec, layer)
+	}
+
+	ef := g.efRuntime
+	if ef < k {
+		ef = k
+	}
+	var candidates []hnswCandidate
+	for {
+		candidates = g.searchLayer(vec, entry, ef, 0, filter)
+		if len(candidates) >= k || ef >= len(g.nodes) {
+			break
+		}
+		ef *= 2
+	}
+
+	results := make([]vectorResult, 0, k)
+	for _, c := range candidates {
+		if len(results) == k {
+			break
+		}
+		results = append(results, vectorResult{doc: c.node.doc, distance: c.distance})
+	}
+	return results
+}
+
+func compareDistance(a float32, b float32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func removeNode(nodes []*hnswNode, node *hnswNode) []*hnswNode {
+	for i, n := range nodes {
+		if n == node {
+			return append(nodes[:i], nodes[i+1:]...)
+		}
+	}
+	return nodes
+}
+
+func containsNode(nodes []*hnswNode, node *hnswNode) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
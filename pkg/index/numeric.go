@@ -0,0 +1,118 @@
+package index
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/emirpasic/gods/utils"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// numericEntrySize approximates memory used by a tree node and a document reference
+const numericEntrySize = 80
+
+type NumericRange struct {
+	Min          float64
+	MinExclusive bool
+	Max          float64
+	MaxExclusive bool
+}
+
+func (r NumericRange) contains(v float64) bool {
+	if v < r.Min || r.MinExclusive && v == r.Min {
+		return false
+	}
+	if v > r.Max || r.MaxExclusive && v == r.Max {
+		return false
+	}
+	return true
+}
+
+// numericIndex keeps documents in a red-black tree by the numeric field value, so that range lookup
+// takes O(log n + m). Readers collect the matching documents under the read lock.
+type numericIndex struct {
+	tree *redblacktree.Tree // float64 -> []*storage.Document
+	size int64
+	mu   sync.RWMutex
+}
+
+func newNumericIndex() *numericIndex {
+	return &numericIndex{tree: redblacktree.NewWith(utils.Float64Comparator)}
+}
+
+func parseNumeric(value []byte) (float64, bool) {
+	v, err := strconv.ParseFloat(string(value), 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, false
+	}
+	return v, true
+}
+
+func (n *numericIndex) add(doc *storage.Document, value []byte) {
+	v, ok := parseNumeric(value)
+	if !ok {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	docs, _ := n.tree.Get(v)
+	if docs == nil {
+		n.tree.Put(v, []*storage.Document{doc})
+	} else {
+		n.tree.Put(v, append(docs.([]*storage.Document), doc))
+	}
+	atomic.AddInt64(&n.size, numericEntrySize)
+}
+
+func (n *numericIndex) remove(doc *storage.Document, value []byte) {
+	v, ok := parseNumeric(value)
+	if !ok {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	found, _ := n.tree.Get(v)
+	if found == nil {
+		return
+	}
+	docs := found.([]*storage.Document)
+	for idx, d := range docs {
+		if d != doc {
+			continue
+		}
+		if len(docs) == 1 {
+			n.tree.Remove(v)
+		} else {
+			n.tree.Put(v, append(docs[:idx:idx], docs[idx+1:]...))
+		}
+		atomic.AddInt64(&n.size, -numericEntrySize)
+		return
+	}
+}
+
+func (n *numericIndex) memoryUsage() int64 {
+	return atomic.LoadInt64(&n.size)
+}
+
+func (n *numericIndex) read(r NumericRange) []*storage.Document {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	result := make([]*storage.Document, 0)
+	node, found := n.tree.Ceiling(r.Min)
+	if !found {
+		return result
+	}
+	it := n.tree.IteratorAt(node)
+	for ok := true; ok; ok = it.Next() {
+		v := it.Key().(float64)
+		if v > r.Max {
+			break
+		}
+		if r.contains(v) {
+			result = append(result, it.Value().([]*storage.Document)...)
+		}
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"math"
+	"testing"
+)
+
+func TestReadNumericBounds(t *testing.T) {
+	idx, s := newTestIndex(t, []idxmodel.Field{{Name: "price", Type: idxmodel.TypeNumeric}}, map[string]storage.Hash{
+		"doc:1": {"price": []byte("10")},
+		"doc:2": {"price": []byte("20")},
+		"doc:3": {"price": []byte("30")},
+		"doc:4": {"price": []byte("cheap")},
+		"doc:5": {"price": []byte("-5.5")},
+		"doc:6": {"price": []byte("1e3")},
+	})
+	inf := math.Inf(1)
+
+	for name, c := range map[string]struct {
+		r        NumericRange
+		expected []string
+	}{
+		"[10 20]":      {NumericRange{Min: 10, Max: 20}, []string{"doc:1", "doc:2"}},
+		"[(10 20]":     {NumericRange{Min: 10, MinExclusive: true, Max: 20}, []string{"doc:2"}},
+		"[10 (20]":     {NumericRange{Min: 10, Max: 20, MaxExclusive: true}, []string{"doc:1"}},
+		"[(10 (20]":    {NumericRange{Min: 10, MinExclusive: true, Max: 20, MaxExclusive: true}, nil},
+		"[(10 (10]":    {NumericRange{Min: 10, MinExclusive: true, Max: 10}, nil},
+		"[10 10]":      {NumericRange{Min: 10, Max: 10}, []string{"doc:1"}},
+		"[-inf +inf]":  {NumericRange{Min: -inf, Max: inf}, []string{"doc:1", "doc:2", "doc:3", "doc:5", "doc:6"}},
+		"[(-inf 0]":    {NumericRange{Min: -inf, MinExclusive: true, Max: 0}, []string{"doc:5"}},
+		"[25 +inf]":    {NumericRange{Min: 25, Max: inf}, []string{"doc:3", "doc:6"}},
+		"[(30 (+inf]":  {NumericRange{Min: 30, MinExclusive: true, Max: inf, MaxExclusive: true}, []string{"doc:6"}},
+		"[2000 +inf]":  {NumericRange{Min: 2000, Max: inf}, nil},
+		"[-10 (-5.5]":  {NumericRange{Min: -10, Max: -5.5, MaxExclusive: true}, nil},
+		"[(-5.5 -5.5]": {NumericRange{Min: -5.5, MinExclusive: true, Max: -5.5}, nil},
+		"[-5.5 -5.5]":  {NumericRange{Min: -5.5, Max: -5.5}, []string{"doc:5"}},
+	} {
+		iter, err := idx.ReadNumeric("price", c.r, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(name, func(t *testing.T) {
+			assertDocs(t, iter, 1, c.expected...)
+		})
+	}
+
+	// the old value of an updated document is removed once the version is reclaimed
+	s.Begin(2)
+	s.Save("doc:1", storage.Hash{"price": []byte("15")})
+	s.Commit()
+	iter, _ := idx.ReadNumeric("price", NumericRange{Min: 10, Max: 10}, 2)
+	assertDocs(t, iter, 2)
+	iter, _ = idx.ReadNumeric("price", NumericRange{Min: 10, Max: 20}, 2)
+	assertDocs(t, iter, 2, "doc:1", "doc:2")
+
+	if _, err := idx.ReadNumeric("missing", NumericRange{Min: -inf, Max: inf}, 2); err == nil {
+		t.Error("expected unknown field to be rejected")
+	}
+}
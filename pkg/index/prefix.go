@@ -0,0 +1,128 @@
+package index
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/pkg/errors"
+	"strings"
+	"sync/atomic"
+)
+
+// formOverhead approximates memory used by a tree node of a word form
+const formOverhead = 80
+
+var errExpansionFull = errors.New("expansion is full")
+
+type wordForm struct {
+	stem string
+	docs int
+}
+
+// wordForms maps words to their stems when they differ, so that prefix queries can find terms
+// whose stems are shorter than the prefix, e.g. "happi" by "happin*". It is guarded by the index lock.
+type wordForms struct {
+	tree *redblacktree.Tree // string -> *wordForm
+	size int64
+}
+
+func newWordForms() *wordForms {
+	return &wordForms{tree: redblacktree.NewWithStringComparator()}
+}
+
+// add counts the forms of a document
+func (w *wordForms) add(forms map[string]string) {
+	for form, stem := range forms {
+		if f, found := w.tree.Get(form); found {
+			f.(*wordForm).docs++
+			continue
+		}
+		w.tree.Put(form, &wordForm{stem: stem, docs: 1})
+		atomic.AddInt64(&w.size, formOverhead+int64(len(form)+len(stem)))
+	}
+}
+
+// remove discounts the forms of a document, the forms not used by any document are dropped
+func (w *wordForms) remove(forms map[string]string) {
+	for form, stem := range forms {
+		f, found := w.tree.Get(form)
+		if !found {
+			continue
+		}
+		if f.(*wordForm).docs--; f.(*wordForm).docs == 0 {
+			w.tree.Remove(form)
+			atomic.AddInt64(&w.size, -formOverhead-int64(len(form)+len(stem)))
+		}
+	}
+}
+
+func (w *wordForms) memoryUsage() int64 {
+	return atomic.LoadInt64(&w.size)
+}
+
+// walk calls the walker for the forms with the prefix and their stems until it returns false
+func (w *wordForms) walk(prefix string, walker func(form string, stem string) bool) {
+	node, found := w.tree.Ceiling(prefix)
+	if !found {
+		return
+	}
+	it := w.tree.IteratorAt(node)
+	for ok := true; ok && strings.HasPrefix(it.Key().(string), prefix); ok = it.Next() {
+		if !walker(it.Key().(string), it.Value().(*wordForm).stem) {
+			return
+		}
+	}
+}
+
+// expansion collects iterators over up to max distinct terms a query term is expanded to,
+// it is used under the index read lock
+type expansion struct {
+	i        *FTSIndex
+	snapshot uint64
+	max      int
+	terms    map[string]bool
+	iters    []TermIterator
+}
+
+func (i *FTSIndex) newExpansion(max int, snapshot uint64) *expansion {
+	return &expansion{i: i, snapshot: snapshot, max: max, terms: make(map[string]bool), iters: make([]TermIterator, 0)}
+}
+
+// add returns false if the term cannot be added as the expansion is full
+func (e *expansion) add(term string, occurrences []DocTermOccurrence) bool {
+	if e.terms[term] {
+		return true
+	}
+	if len(e.terms) == e.max {
+		return false
+	}
+	if occurrences == nil {
+		if occurrences = e.i.trie.Get(term); occurrences == nil {
+			return true
+		}
+	}
+	e.terms[term] = true
+	e.iters = append(e.iters, &readIterator{i: e.i, snapshot: e.snapshot, term: term, idf: e.i.idf(term), occurrences: occurrences})
+	return true
+}
+
+// ReadPrefix returns iterators over occurrences of up to maxExpansions terms starting with the prefix.
+// The prefix is not stemmed, it is matched against both words and their stems.
+func (i *FTSIndex) ReadPrefix(prefix string, maxExpansions int, snapshot uint64) []TermIterator {
+	prefix = strings.ToLower(prefix)
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	e := i.newExpansion(maxExpansions, snapshot)
+	err := i.trie.WalkPrefix(prefix, func(term string, occurrences []DocTermOccurrence) error {
+		if !e.add(term, occurrences) {
+			return errExpansionFull
+		}
+		return nil
+	})
+	if err == nil {
+		i.forms.walk(prefix, func(form string, stem string) bool {
+			return e.add(stem, nil)
+		})
+	}
+	return e.iters
+}
@@ -0,0 +1,165 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// sortKeysOverhead approximates memory used by a map entry and the keys slice of a document
+const sortKeysOverhead = 64
+
+// sortKeyOverhead approximates memory used by a single sort key
+const sortKeyOverhead = 48
+
+// SortKey is the normalized value of a sortable field: numeric fields are compared as numbers,
+// other fields as case-folded strings
+type SortKey struct {
+	Numeric bool
+	Num     float64
+	Str     string
+}
+
+func (k SortKey) Less(other SortKey) bool {
+	if k.Numeric {
+		return k.Num < other.Num
+	}
+	return k.Str < other.Str
+}
+
+// String formats the key the way RediSearch returns sort keys: # prefixes numbers and $ prefixes strings
+func (k SortKey) String() string {
+	if k.Numeric {
+		return "#" + strconv.FormatFloat(k.Num, 'g', -1, 64)
+	}
+	return "$" + k.Str
+}
+
+// sortKeys keeps sort keys of the sortable fields per document version, it is changed only by the index writer.
+// Keys of a document are never modified, so readers can use them without the lock.
+type sortKeys struct {
+	fields  map[string]int // sortable field -> position of its key
+	numeric []bool
+	keys    map[*storage.Document][]*SortKey
+	size    int64
+	mu      sync.RWMutex
+}
+
+// newSortKeys returns nil if the schema has no sortable fields
+func newSortKeys(schema []idxmodel.Field) *sortKeys {
+	s := &sortKeys{fields: make(map[string]int), keys: make(map[*storage.Document][]*SortKey)}
+	for _, f := range schema {
+		if f.Sortable {
+			s.fields[f.Name] = len(s.numeric)
+			s.numeric = append(s.numeric, f.Type == idxmodel.TypeNumeric)
+		}
+	}
+	if len(s.fields) == 0 {
+		return nil
+	}
+	return s
+}
+
+func (s *sortKeys) add(doc *storage.Document, hash storage.Hash) {
+	if s == nil {
+		return
+	}
+	keys := make([]*SortKey, len(s.numeric))
+	size := int64(sortKeysOverhead + 8*len(keys))
+	for field, idx := range s.fields {
+		value, ok := hash[field]
+		if !ok {
+			continue
+		}
+		if !s.numeric[idx] {
+			keys[idx] = &SortKey{Str: strings.ToLower(string(value))}
+			size += sortKeyOverhead + int64(len(value))
+			continue
+		}
+		if v, ok := parseNumeric(value); ok {
+			keys[idx] = &SortKey{Numeric: true, Num: v}
+			size += sortKeyOverhead
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[doc] = keys
+	atomic.AddInt64(&s.size, size)
+}
+
+func (s *sortKeys) remove(doc *storage.Document) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, found := s.keys[doc]
+	if !found {
+		return
+	}
+	delete(s.keys, doc)
+	size := int64(sortKeysOverhead + 8*len(keys))
+	for _, k := range keys {
+		if k != nil {
+			size += sortKeyOverhead + int64(len(k.Str))
+		}
+	}
+	atomic.AddInt64(&s.size, -size)
+}
+
+// get returns nil if the document has no value of the field
+func (s *sortKeys) get(doc *storage.Document, idx int) *SortKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if keys, found := s.keys[doc]; found {
+		return keys[idx]
+	}
+	return nil
+}
+
+func (s *sortKeys) memoryUsage() int64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.size)
+}
+
+// sortKeyIterator fills sort keys of the field in the occurrences of the iterator
+type sortKeyIterator struct {
+	iter TermIterator
+	keys *sortKeys
+	idx  int
+}
+
+func (s *sortKeyIterator) Next() (occurrence DocTermOccurrence, score float32, ok bool) {
+	occurrence, score, ok = s.iter.Next()
+	if ok {
+		occurrence.SortKey = s.keys.get(occurrence.Doc, s.idx)
+	}
+	return
+}
+
+// SortBy returns iterator filling DocTermOccurrence.SortKey with the key of the sortable field
+func (i *FTSIndex) SortBy(field string, iter TermIterator) (TermIterator, error) {
+	idx, ok := 0, false
+	if i.sortKeys != nil {
+		idx, ok = i.sortKeys.fields[field]
+	}
+	if !ok {
+		for _, f := range i.schema {
+			if f.Name == field {
+				return nil, errors.Errorf("Field `%s` is not sortable", field)
+			}
+		}
+		return nil, errors.Errorf("Unknown field `%s`", field)
+	}
+	if _, ok := iter.(StopWordIterator); ok {
+		return iter, nil
+	}
+	return &sortKeyIterator{iter: iter, keys: i.sortKeys, idx: idx}, nil
+}
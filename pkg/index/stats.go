@@ -0,0 +1,60 @@
+package index
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"strings"
+	"sync/atomic"
+)
+
+type Stats struct {
+	Docs             int
+	Terms            int
+	Postings         int64
+	AvgPostingLength float64
+	PostingBytes     int64
+	PositionBytes    int64
+	TopTerms         []TermStats // terms with the longest posting lists, longest first
+}
+
+type TermStats struct {
+	Term     string
+	Postings int
+}
+
+// newTermRanking returns tree of TermStats keys ordered by descending number of postings, then by term
+func newTermRanking() *redblacktree.Tree {
+	return redblacktree.NewWith(func(a, b interface{}) int {
+		t1, t2 := a.(TermStats), b.(TermStats)
+		if t1.Postings != t2.Postings {
+			return t2.Postings - t1.Postings
+		}
+		return strings.Compare(t1.Term, t2.Term)
+	})
+}
+
+// Stats returns cardinality and memory statistics of the index. Counters and the term ranking are maintained
+// by the writer, so the only work done here is reading top terms from the head of the ranking.
+func (i *FTSIndex) Stats(top int) Stats {
+	stats := Stats{
+		Docs:          int(atomic.LoadInt32(&i.docsCount)),
+		Terms:         int(atomic.LoadInt64(&i.terms)),
+		Postings:      atomic.LoadInt64(&i.postings),
+		PostingBytes:  atomic.LoadInt64(&i.postingBytes),
+		PositionBytes: atomic.LoadInt64(&i.positionBytes),
+	}
+	if stats.Terms > 0 {
+		stats.AvgPostingLength = float64(stats.Postings) / float64(stats.Terms)
+	}
+
+	stats.TopTerms = make([]TermStats, 0)
+	if top <= 0 {
+		return stats
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	it := i.ranking.Iterator()
+	for len(stats.TopTerms) < top && it.Next() {
+		stats.TopTerms = append(stats.TopTerms, it.Key().(TermStats))
+	}
+	return stats
+}
@@ -0,0 +1,54 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"testing"
+)
+
+func TestStatsRankTermsAtWriteTime(t *testing.T) {
+	s := storage.NewMemory()
+	idx := NewFTSIndex(s, []string{"doc:"}, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}})
+	idx.Load(nil)
+	s.OnSave(idx.Add)
+	s.OnDelete(idx.Remove)
+
+	s.Begin(1)
+	s.Save("doc:1", storage.Hash{"title": []byte("hello world")})
+	s.Save("doc:2", storage.Hash{"title": []byte("hello planet")})
+	s.Save("doc:3", storage.Hash{"title": []byte("hello world again")})
+	s.Commit()
+
+	stats := idx.Stats(2)
+	if stats.Terms != 4 || stats.Postings != 7 {
+		t.Fatalf("expected 4 terms and 7 postings, got %d and %d", stats.Terms, stats.Postings)
+	}
+	assertTopTerms(t, stats.TopTerms, TermStats{"hello", 3}, TermStats{"world", 2})
+
+	s.Begin(2)
+	s.Delete("doc:1")
+	s.Delete("doc:3")
+	s.Commit()
+	s.Snapshot().Release()
+
+	stats = idx.Stats(10)
+	if stats.Terms != 2 || stats.Postings != 2 {
+		t.Fatalf("expected 2 terms and 2 postings, got %d and %d", stats.Terms, stats.Postings)
+	}
+	assertTopTerms(t, stats.TopTerms, TermStats{"hello", 1}, TermStats{"planet", 1})
+	if top := idx.Stats(0).TopTerms; len(top) != 0 {
+		t.Errorf("expected no top terms, got %v", top)
+	}
+}
+
+func assertTopTerms(t *testing.T, actual []TermStats, expected ...TermStats) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected top terms %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected top terms %v, got %v", expected, actual)
+		}
+	}
+}
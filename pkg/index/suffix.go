@@ -0,0 +1,125 @@
+package index
+
+import (
+	"sync/atomic"
+)
+
+// suffixOverhead approximates memory used by a suffix trie entry of a word
+const suffixOverhead = 64
+
+type suffixNode struct {
+	children map[rune]*suffixNode
+	words    map[string]bool // words ending with the path to the node
+}
+
+type suffixWord struct {
+	term string
+	docs int
+}
+
+// suffixTrie is a trie of all suffixes of the words of fields with the suffix trie enabled,
+// so words containing a string are found in the subtree of the node at the string.
+// It is guarded by the index lock.
+type suffixTrie struct {
+	root  *suffixNode
+	words map[string]*suffixWord
+	size  int64
+}
+
+func newSuffixTrie() *suffixTrie {
+	return &suffixTrie{root: &suffixNode{}, words: make(map[string]*suffixWord)}
+}
+
+// add counts the words of a document mapped to their terms
+func (t *suffixTrie) add(words map[string]string) {
+	for word, term := range words {
+		if w, found := t.words[word]; found {
+			w.docs++
+			continue
+		}
+		t.words[word] = &suffixWord{term: term, docs: 1}
+		runes := []rune(word)
+		for i := range runes {
+			node := t.root
+			for _, r := range runes[i:] {
+				child := node.children[r]
+				if child == nil {
+					if node.children == nil {
+						node.children = make(map[rune]*suffixNode)
+					}
+					child = &suffixNode{}
+					node.children[r] = child
+				}
+				node = child
+			}
+			if node.words == nil {
+				node.words = make(map[string]bool)
+			}
+			node.words[word] = true
+		}
+		atomic.AddInt64(&t.size, int64(len(runes))*(suffixOverhead+int64(len(word))))
+	}
+}
+
+// remove discounts the words of a document, the suffixes of words not used by any document are dropped
+func (t *suffixTrie) remove(words map[string]string) {
+	for word := range words {
+		w, found := t.words[word]
+		if !found {
+			continue
+		}
+		if w.docs--; w.docs > 0 {
+			continue
+		}
+		delete(t.words, word)
+		runes := []rune(word)
+		for i := range runes {
+			t.root.removeSuffix(runes[i:], word)
+		}
+		atomic.AddInt64(&t.size, -int64(len(runes))*(suffixOverhead+int64(len(word))))
+	}
+}
+
+// removeSuffix drops the word from the node at the suffix, returns true if the node became empty
+func (n *suffixNode) removeSuffix(suffix []rune, word string) bool {
+	if len(suffix) == 0 {
+		delete(n.words, word)
+	} else if child := n.children[suffix[0]]; child != nil && child.removeSuffix(suffix[1:], word) {
+		delete(n.children, suffix[0])
+	}
+	return len(n.words) == 0 && len(n.children) == 0
+}
+
+// containing calls the walker for words containing the string and their terms until it returns false
+func (t *suffixTrie) containing(s string, walker func(word string, term string) bool) {
+	node := t.root
+	for _, r := range s {
+		if node = node.children[r]; node == nil {
+			return
+		}
+	}
+	node.walk(func(word string) bool {
+		return walker(word, t.words[word].term)
+	})
+}
+
+func (n *suffixNode) walk(walker func(word string) bool) bool {
+	for word := range n.words {
+		if !walker(word) {
+			return false
+		}
+	}
+	for _, child := range n.children {
+		if !child.walk(walker) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *suffixTrie) memoryUsage() int64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&t.size)
+}
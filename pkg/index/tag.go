@@ -0,0 +1,100 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// tagOverhead approximates memory used by a map entry of a tag
+const tagOverhead = 64
+
+// tagIndex is an inverted index of exact tag values of a field, values are neither stemmed nor filtered by stop words
+type tagIndex struct {
+	separator     string
+	caseSensitive bool
+	m             map[string][]*storage.Document
+	size          int64
+	mu            sync.RWMutex
+}
+
+func newTagIndex(separator string, caseSensitive bool) *tagIndex {
+	return &tagIndex{separator: separator, caseSensitive: caseSensitive, m: map[string][]*storage.Document{}}
+}
+
+// normalize returns tag as it is stored in the index, empty string means no tag
+func (t *tagIndex) normalize(tag string) string {
+	tag = strings.TrimSpace(tag)
+	if !t.caseSensitive {
+		tag = strings.ToLower(tag)
+	}
+	return tag
+}
+
+func (t *tagIndex) tags(value []byte) map[string]bool {
+	tags := make(map[string]bool)
+	for _, tag := range strings.Split(string(value), t.separator) {
+		if tag = t.normalize(tag); tag != "" {
+			tags[tag] = true
+		}
+	}
+	return tags
+}
+
+func (t *tagIndex) add(doc *storage.Document, value []byte) {
+	tags := t.tags(value)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tag := range tags {
+		docs, found := t.m[tag]
+		if !found {
+			atomic.AddInt64(&t.size, tagOverhead+int64(len(tag)))
+		}
+		t.m[tag] = append(docs, doc)
+		atomic.AddInt64(&t.size, 8)
+	}
+}
+
+func (t *tagIndex) remove(doc *storage.Document, value []byte) {
+	tags := t.tags(value)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tag := range tags {
+		docs := t.m[tag]
+		for idx, d := range docs {
+			if d != doc {
+				continue
+			}
+			if len(docs) == 1 {
+				delete(t.m, tag)
+				atomic.AddInt64(&t.size, -tagOverhead-int64(len(tag)))
+			} else {
+				t.m[tag] = append(docs[:idx:idx], docs[idx+1:]...)
+			}
+			atomic.AddInt64(&t.size, -8)
+			break
+		}
+	}
+}
+
+func (t *tagIndex) memoryUsage() int64 {
+	return atomic.LoadInt64(&t.size)
+}
+
+// read returns documents having any of the tags, every document is returned once
+func (t *tagIndex) read(tags []string) []*storage.Document {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	result := make([]*storage.Document, 0)
+	seen := make(map[*storage.Document]bool)
+	for _, tag := range tags {
+		for _, doc := range t.m[t.normalize(tag)] {
+			if !seen[doc] {
+				seen[doc] = true
+				result = append(result, doc)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,51 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"testing"
+)
+
+func TestReadTags(t *testing.T) {
+	schema := []idxmodel.Field{
+		{Name: "category", Type: idxmodel.TypeTag},
+		{Name: "code", Type: idxmodel.TypeTag, Separator: ";", CaseSensitive: true},
+	}
+	idx, s := newTestIndex(t, schema, map[string]storage.Hash{
+		"doc:1": {"category": []byte("POLITICS, World News"), "code": []byte("AB;cd")},
+		"doc:2": {"category": []byte("world news"), "code": []byte("ab,CD")},
+		"doc:3": {"category": []byte("The Running,,  "), "code": []byte("  AB  ")},
+	})
+
+	offset := uint64(1)
+	read := func(field string, tags ...string) TermIterator {
+		t.Helper()
+		iter, err := idx.ReadTags(field, tags, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iter
+	}
+	// tags are trimmed and case-folded, but neither stemmed nor filtered by stop words
+	assertDocs(t, read("category", "world news"), 1, "doc:1", "doc:2")
+	assertDocs(t, read("category", "POLITICS", "WORLD NEWS"), 1, "doc:1", "doc:2")
+	assertDocs(t, read("category", "the running"), 1, "doc:3")
+	assertDocs(t, read("category", "run"), 1)
+	assertDocs(t, read("category", "world"), 1)
+	assertDocs(t, read("category", ""), 1)
+	// the custom separator is used and the case is kept
+	assertDocs(t, read("code", "AB"), 1, "doc:1", "doc:3")
+	assertDocs(t, read("code", "ab"), 1)
+	assertDocs(t, read("code", "ab,CD"), 1, "doc:2")
+
+	s.Begin(2)
+	s.Save("doc:1", storage.Hash{"category": []byte("sport")})
+	s.Commit()
+	offset = 2
+	assertDocs(t, read("category", "politics"), 2)
+	assertDocs(t, read("category", "sport", "world news"), 2, "doc:1", "doc:2")
+
+	if _, err := idx.ReadTags("missing", []string{"sport"}, 2); err == nil {
+		t.Error("expected unknown field to be rejected")
+	}
+}
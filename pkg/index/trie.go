@@ -1,26 +1,42 @@
 package index
 
-import "sort"
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sort"
+	"sync/atomic"
+)
 
 // WalkFunc defines some action to take on the given key and value during
 // a Trie Walk. Returning a non-nil error will terminate the Walk.
 type WalkFunc func(key string, value []DocTermOccurrence) error
 
+// FuzzyWalkFunc is called by the fuzzy walk with the Levenshtein distance of the key to the searched word.
+// Returning a non-nil error will terminate the walk.
+type FuzzyWalkFunc func(key string, value []DocTermOccurrence, distance int) error
+
 // Trier exposes the Trie structure capabilities.
 type Trier interface {
 	Get(key string) []DocTermOccurrence
 	Put(key string, value []DocTermOccurrence) bool
 	Add(key string, value DocTermOccurrence)
+	Merge(key string, values []DocTermOccurrence)
+	Remove(key string, doc *storage.Document) bool
 	Delete(key string) bool
 	Walk(walker WalkFunc) error
 	WalkPath(key string, walker WalkFunc) error
+	WalkPrefix(prefix string, walker WalkFunc) error
+	WalkFuzzy(word string, distance int, walker FuzzyWalkFunc) error
 }
 
 // RuneTrie is a trie of runes with string keys and interface{} values.
 // Note that internal nodes have nil values so a stored nil value will not
 // be distinguishable and will not be included in Walks.
+//
+// Values handed to readers by Get and the walks are never modified: such value is marked as shared
+// and the writer replaces it with a copy, values no reader has seen are changed in place.
 type RuneTrie struct {
 	value    []DocTermOccurrence
+	shared   int32 // 1 if the value could be held by a reader
 	children map[rune]*RuneTrie
 }
 
@@ -39,7 +55,26 @@ func (trie *RuneTrie) Get(key string) []DocTermOccurrence {
 			return nil
 		}
 	}
-	return node.value
+	return node.read()
+}
+
+// read returns the value marking it as shared, it is safe to call concurrently under the read lock of the trie owner
+func (trie *RuneTrie) read() []DocTermOccurrence {
+	if trie.value != nil && atomic.LoadInt32(&trie.shared) == 0 {
+		atomic.StoreInt32(&trie.shared, 1)
+	}
+	return trie.value
+}
+
+// mutable returns the value if it can be changed in place, otherwise a copy with the capacity for n more occurrences
+func (trie *RuneTrie) mutable(n int) []DocTermOccurrence {
+	if atomic.LoadInt32(&trie.shared) == 0 {
+		return trie.value
+	}
+	values := make([]DocTermOccurrence, len(trie.value), len(trie.value)+n)
+	copy(values, trie.value)
+	atomic.StoreInt32(&trie.shared, 0)
+	return values
 }
 
 // Put inserts the value into the trie at the given key, replacing any
@@ -63,10 +98,48 @@ func (trie *RuneTrie) Put(key string, value []DocTermOccurrence) bool {
 	// does node have an existing value?
 	isNewVal := node.value == nil
 	node.value = value
+	// the caller keeps the value
+	atomic.StoreInt32(&node.shared, 1)
 	return isNewVal
 }
 
+// Add inserts the occurrence into the value stored at the given key keeping it sorted by document key.
+// The stored slice is copied only if a reader could hold it, so values returned by Get
+// can be read while the trie is changed.
 func (trie *RuneTrie) Add(key string, value DocTermOccurrence) {
+	node := trie.node(key)
+	idx := sort.Search(len(node.value), func(i int) bool {
+		return node.value[i].Doc.Key >= value.Doc.Key
+	})
+	values := append(node.mutable(len(node.value)/4+1), DocTermOccurrence{})
+	copy(values[idx+1:], values[idx:])
+	values[idx] = value
+	node.value = values
+}
+
+// Merge inserts the occurrences sorted by document key into the value stored at the given key.
+// It always replaces the stored slice with a new one, as the merge copies the occurrences anyway.
+func (trie *RuneTrie) Merge(key string, values []DocTermOccurrence) {
+	node := trie.node(key)
+	merged := make([]DocTermOccurrence, 0, len(node.value)+len(values))
+	i, j := 0, 0
+	for i < len(node.value) && j < len(values) {
+		if node.value[i].Doc.Key <= values[j].Doc.Key {
+			merged = append(merged, node.value[i])
+			i++
+		} else {
+			merged = append(merged, values[j])
+			j++
+		}
+	}
+	merged = append(merged, node.value[i:]...)
+	merged = append(merged, values[j:]...)
+	node.value = merged
+	atomic.StoreInt32(&node.shared, 0)
+}
+
+// node returns the node at the given key creating it and the missing ancestors
+func (trie *RuneTrie) node(key string) *RuneTrie {
 	node := trie
 	for _, r := range key {
 		child, _ := node.children[r]
@@ -79,30 +152,48 @@ func (trie *RuneTrie) Add(key string, value DocTermOccurrence) {
 		}
 		node = child
 	}
-	if node.value == nil {
-		node.value = []DocTermOccurrence{value}
-	} else {
-		idx := sort.Search(len(node.value), func(i int) bool {
-			return node.value[i].Doc.Key >= value.Doc.Key
-		})
-		node.value = append(node.value, DocTermOccurrence{})
-		if idx < len(node.value)-1 {
-			copy(node.value[idx+1:], node.value[idx:])
-		}
-		node.value[idx] = value
+	return node
+}
 
-		//node.value = append(node.value, value)
+// Remove removes the occurrence of the given document version from the value stored at the given key.
+// Returns true if the occurrence was found. The key is deleted if no occurrences are left.
+// Like Add, it copies the stored slice only if a reader could hold it.
+func (trie *RuneTrie) Remove(key string, doc *storage.Document) bool {
+	node := trie
+	for _, r := range key {
+		node = node.children[r]
+		if node == nil {
+			return false
+		}
 	}
+	idx := sort.Search(len(node.value), func(i int) bool {
+		return node.value[i].Doc.Key >= doc.Key
+	})
+	for ; idx < len(node.value) && node.value[idx].Doc.Key == doc.Key; idx++ {
+		if node.value[idx].Doc != doc {
+			continue
+		}
+		if len(node.value) == 1 {
+			trie.Delete(key)
+			return true
+		}
+		values := node.mutable(0)
+		copy(values[idx:], values[idx+1:])
+		values[len(values)-1] = DocTermOccurrence{}
+		node.value = values[:len(values)-1]
+		return true
+	}
+	return false
 }
 
 // Delete removes the value associated with the given key. Returns true if a
 // node was found for the given key. If the node or any of its ancestors
 // becomes childless as a result, it is removed from the trie.
 func (trie *RuneTrie) Delete(key string) bool {
-	path := make([]nodeRune, len(key)) // record ancestors to check later
+	path := make([]nodeRune, 0, len(key)) // record ancestors to check later, key offsets are bytes, not runes
 	node := trie
-	for i, r := range key {
-		path[i] = nodeRune{r: r, node: node}
+	for _, r := range key {
+		path = append(path, nodeRune{r: r, node: node})
 		node = node.children[r]
 		if node == nil {
 			// node does not exist
@@ -115,7 +206,7 @@ func (trie *RuneTrie) Delete(key string) bool {
 	// path.
 	if node.isLeaf() {
 		// iterate backwards over path
-		for i := len(key) - 1; i >= 0; i-- {
+		for i := len(path) - 1; i >= 0; i-- {
 			parent := path[i].node
 			r := path[i].r
 			delete(parent.children, r)
@@ -147,7 +238,7 @@ func (trie *RuneTrie) Walk(walker WalkFunc) error {
 func (trie *RuneTrie) WalkPath(key string, walker WalkFunc) error {
 	// Get root value if one exists.
 	if trie.value != nil {
-		if err := walker("", trie.value); err != nil {
+		if err := walker("", trie.read()); err != nil {
 			return err
 		}
 	}
@@ -157,7 +248,73 @@ func (trie *RuneTrie) WalkPath(key string, walker WalkFunc) error {
 			return nil
 		}
 		if trie.value != nil {
-			if err := walker(key[0:i+1], trie.value); err != nil {
+			if err := walker(key[0:i+1], trie.read()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WalkPrefix iterates over each key/value stored in the subtree of the node at the given prefix.
+// The traversal is depth first with no guaranteed order.
+func (trie *RuneTrie) WalkPrefix(prefix string, walker WalkFunc) error {
+	node := trie
+	for _, r := range prefix {
+		if node = node.children[r]; node == nil {
+			return nil
+		}
+	}
+	return node.walk(prefix, walker)
+}
+
+// WalkFuzzy iterates over each key/value stored in the trie within the Levenshtein distance of the word.
+// Each node extends the row of edit distances of its parent the way a Levenshtein automaton does,
+// subtrees are skipped once no prefix in them can be within the distance.
+// The traversal is depth first with no guaranteed order.
+func (trie *RuneTrie) WalkFuzzy(word string, distance int, walker FuzzyWalkFunc) error {
+	runes := []rune(word)
+	row := make([]int, len(runes)+1)
+	for i := range row {
+		row[i] = i
+	}
+	if trie.value != nil && row[len(runes)] <= distance {
+		if err := walker("", trie.read(), row[len(runes)]); err != nil {
+			return err
+		}
+	}
+	return trie.walkFuzzy("", runes, row, distance, walker)
+}
+
+func (trie *RuneTrie) walkFuzzy(key string, word []rune, prevRow []int, distance int, walker FuzzyWalkFunc) error {
+	for r, child := range trie.children {
+		row := make([]int, len(prevRow))
+		row[0] = prevRow[0] + 1
+		minDistance := row[0]
+		for j := 1; j < len(row); j++ {
+			cost := 1
+			if word[j-1] == r {
+				cost = 0
+			}
+			row[j] = prevRow[j-1] + cost // substitution
+			if row[j-1]+1 < row[j] {
+				row[j] = row[j-1] + 1 // insertion
+			}
+			if prevRow[j]+1 < row[j] {
+				row[j] = prevRow[j] + 1 // deletion
+			}
+			if row[j] < minDistance {
+				minDistance = row[j]
+			}
+		}
+		childKey := key + string(r)
+		if child.value != nil && row[len(word)] <= distance {
+			if err := walker(childKey, child.read(), row[len(word)]); err != nil {
+				return err
+			}
+		}
+		if minDistance <= distance {
+			if err := child.walkFuzzy(childKey, word, row, distance, walker); err != nil {
 				return err
 			}
 		}
@@ -173,7 +330,7 @@ type nodeRune struct {
 
 func (trie *RuneTrie) walk(key string, walker WalkFunc) error {
 	if trie.value != nil {
-		if err := walker(key, trie.value); err != nil {
+		if err := walker(key, trie.read()); err != nil {
 			return err
 		}
 	}
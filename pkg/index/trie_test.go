@@ -0,0 +1,78 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"testing"
+)
+
+func TestRuneTrieRemoveMultiByteKey(t *testing.T) {
+	trie := NewRuneTrie()
+	cafe := &storage.Document{Key: "doc:1"}
+	car := &storage.Document{Key: "doc:2"}
+	trie.Add("café", DocTermOccurrence{Doc: cafe})
+	trie.Add("car", DocTermOccurrence{Doc: car})
+
+	if !trie.Remove("café", cafe) {
+		t.Fatal("expected café occurrence to be removed")
+	}
+	if v := trie.Get("café"); v != nil {
+		t.Errorf("expected no occurrences of café, got %v", v)
+	}
+	if v := trie.Get("car"); len(v) != 1 || v[0].Doc != car {
+		t.Errorf("expected car to keep its occurrence, got %v", v)
+	}
+	if _, found := trie.children['c'].children['a'].children['f']; found {
+		t.Error("expected the node of café to be unlinked")
+	}
+	if trie.Remove("café", cafe) {
+		t.Error("expected removed occurrence not to be found")
+	}
+}
+
+func TestRuneTrieDeleteKeepsAncestorsWithValues(t *testing.T) {
+	trie := NewRuneTrie()
+	trie.Add("né", DocTermOccurrence{Doc: &storage.Document{Key: "doc:1"}})
+	trie.Add("née", DocTermOccurrence{Doc: &storage.Document{Key: "doc:2"}})
+
+	if !trie.Delete("née") {
+		t.Fatal("expected née to be deleted")
+	}
+	if v := trie.Get("né"); len(v) != 1 {
+		t.Errorf("expected né to keep its occurrence, got %v", v)
+	}
+	if trie.Get("née") != nil {
+		t.Error("expected née to be deleted")
+	}
+}
+
+func TestRuneTrieKeepsValuesReadByReaders(t *testing.T) {
+	trie := NewRuneTrie()
+	docs := []*storage.Document{{Key: "doc:1"}, {Key: "doc:2"}, {Key: "doc:3"}, {Key: "doc:4"}}
+	trie.Add("hello", DocTermOccurrence{Doc: docs[1]})
+	trie.Add("hello", DocTermOccurrence{Doc: docs[3]})
+	trie.Add("hello", DocTermOccurrence{Doc: docs[0]})
+
+	read := trie.Get("hello")
+	trie.Add("hello", DocTermOccurrence{Doc: docs[2]})
+	trie.Remove("hello", docs[0])
+
+	assertOccurrences(t, read, "doc:1", "doc:2", "doc:4")
+	assertOccurrences(t, trie.Get("hello"), "doc:2", "doc:3", "doc:4")
+
+	// the value nobody read since the last copy is changed in place
+	trie.Add("hello", DocTermOccurrence{Doc: docs[0]})
+	trie.Remove("hello", docs[3])
+	assertOccurrences(t, trie.Get("hello"), "doc:1", "doc:2", "doc:3")
+}
+
+func assertOccurrences(t *testing.T, occurrences []DocTermOccurrence, keys ...string) {
+	t.Helper()
+	if len(occurrences) != len(keys) {
+		t.Fatalf("expected occurrences in %v, got %d occurrences", keys, len(occurrences))
+	}
+	for i, key := range keys {
+		if occurrences[i].Doc.Key != key {
+			t.Fatalf("expected occurrence %d in %s, got %s", i, key, occurrences[i].Doc.Key)
+		}
+	}
+}
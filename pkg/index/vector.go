@@ -0,0 +1,217 @@
+package index
+
+import (
+	"encoding/binary"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// vectorOverhead approximates memory used by a map entry and slice header of a vector
+const vectorOverhead = 64
+
+// bruteForceRatio is the max share of the indexed vectors which are compared one by one
+// when a filter is set, otherwise the filter is applied while traversing the HNSW graph
+const bruteForceRatio = 0.1
+
+type KNNQuery struct {
+	K          int
+	Vector     []byte
+	ScoreField string
+}
+
+type vectorResult struct {
+	doc      *storage.Document
+	distance float32
+}
+
+// vectorIndex keeps vectors of a field decoded from binary blobs, KNN search compares all of them
+// for FLAT algorithm or traverses the HNSW graph
+type vectorIndex struct {
+	opts     idxmodel.VectorOptions
+	distance func(a []float32, b []float32) float32
+	vectors  map[*storage.Document][]float32
+	hnsw     *hnswGraph // nil for FLAT
+	size     int64
+	mu       sync.RWMutex
+}
+
+func newVectorIndex(opts idxmodel.VectorOptions) *vectorIndex {
+	v := &vectorIndex{opts: opts, vectors: map[*storage.Document][]float32{}}
+	switch opts.Metric {
+	case idxmodel.MetricL2:
+		v.distance = l2Distance
+	default:
+		// cosine vectors are normalized, so the distance is the same as for the inner product
+		v.distance = ipDistance
+	}
+	if opts.Algorithm == idxmodel.AlgorithmHNSW {
+		v.hnsw = newHNSWGraph(opts, v.distance)
+	}
+	return v
+}
+
+// decode converts little-endian blob to a vector of the index dimension
+func (v *vectorIndex) decode(blob []byte) ([]float32, error) {
+	size := 4
+	if v.opts.Type == idxmodel.VectorFloat64 {
+		size = 8
+	}
+	if len(blob) != v.opts.Dim*size {
+		return nil, errors.Errorf("vector blob size %d does not match dimension %d of %s",
+			len(blob), v.opts.Dim, v.opts.Type)
+	}
+	vec := make([]float32, v.opts.Dim)
+	for i := range vec {
+		if size == 4 {
+			vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[i*4:]))
+		} else {
+			vec[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(blob[i*8:])))
+		}
+	}
+	if v.opts.Metric == idxmodel.MetricCosine {
+		normalize(vec)
+	}
+	return vec, nil
+}
+
+func (v *vectorIndex) add(doc *storage.Document, value []byte) {
+	vec, err := v.decode(value)
+	if err != nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.vectors[doc] = vec
+	size := int64(vectorOverhead + 4*len(vec))
+	if v.hnsw != nil {
+		size += v.hnsw.insert(doc, vec)
+	}
+	atomic.AddInt64(&v.size, size)
+}
+
+func (v *vectorIndex) remove(doc *storage.Document, _ []byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	vec, ok := v.vectors[doc]
+	if !ok {
+		return
+	}
+	delete(v.vectors, doc)
+	size := int64(vectorOverhead + 4*len(vec))
+	if v.hnsw != nil {
+		size += v.hnsw.remove(doc)
+	}
+	atomic.AddInt64(&v.size, -size)
+}
+
+func (v *vectorIndex) memoryUsage() int64 {
+	return atomic.LoadInt64(&v.size)
+}
+
+// search returns k nearest vectors of document versions visible at the snapshot sorted by distance,
+// candidates limit the documents if not nil
+func (v *vectorIndex) search(query []float32, k int, candidates []*storage.Document, snapshot uint64) []vectorResult {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.hnsw != nil && (candidates == nil || float64(len(candidates)) > bruteForceRatio*float64(len(v.vectors))) {
+		var allowed map[*storage.Document]bool
+		if candidates != nil {
+			allowed = make(map[*storage.Document]bool, len(candidates))
+			for _, doc := range candidates {
+				allowed[doc] = true
+			}
+		}
+		return v.hnsw.search(query, k, func(doc *storage.Document) bool {
+			return doc.VisibleAt(snapshot) && (allowed == nil || allowed[doc])
+		})
+	}
+
+	results := make([]vectorResult, 0)
+	compare := func(doc *storage.Document, vec []float32) {
+		if doc.VisibleAt(snapshot) {
+			results = append(results, vectorResult{doc: doc, distance: v.distance(query, vec)})
+		}
+	}
+	if candidates == nil {
+		for doc, vec := range v.vectors {
+			compare(doc, vec)
+		}
+	} else {
+		for _, doc := range candidates {
+			if vec, ok := v.vectors[doc]; ok {
+				compare(doc, vec)
+			}
+		}
+	}
+	sortResults(results)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+func sortResults(results []vectorResult) {
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].distance != results[b].distance {
+			return results[a].distance < results[b].distance
+		}
+		return results[a].doc.Key < results[b].doc.Key
+	})
+}
+
+// knnIterator returns nearest documents sorted by key, the closer is the document the higher is its score
+type knnIterator struct {
+	results    []vectorResult
+	scoreField string
+	pos        int
+}
+
+func (k *knnIterator) Next() (occurrence DocTermOccurrence, score float32, ok bool) {
+	if k.pos == len(k.results) {
+		ok = false
+		return
+	}
+	r := k.results[k.pos]
+	k.pos++
+	distance := []byte(strconv.FormatFloat(float64(r.distance), 'g', -1, 32))
+	occurrence = DocTermOccurrence{Doc: r.doc, Computed: storage.Hash{k.scoreField: distance}}
+	return occurrence, -r.distance, true
+}
+
+func l2Distance(a []float32, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+func ipDistance(a []float32, b []float32) float32 {
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return 1 - dot
+}
+
+func normalize(vec []float32) {
+	var norm float64
+	for _, x := range vec {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return
+	}
+	norm = math.Sqrt(norm)
+	for i := range vec {
+		vec[i] = float32(float64(vec[i]) / norm)
+	}
+}
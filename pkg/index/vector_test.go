@@ -0,0 +1,161 @@
+package index
+
+import (
+	"encoding/binary"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func float32Blob(values ...float32) []byte {
+	blob := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(blob[i*4:], math.Float32bits(v))
+	}
+	return blob
+}
+
+func vectorField(name string, algorithm string, metric string, dim int) idxmodel.Field {
+	return idxmodel.Field{Name: name, Type: idxmodel.TypeVector, Vector: &idxmodel.VectorOptions{
+		Algorithm: algorithm, Type: idxmodel.VectorFloat32, Dim: dim, Metric: metric}}
+}
+
+// readKNN returns keys of the nearest documents with their distances
+func readKNN(t *testing.T, idx *FTSIndex, field string, k int, filter TermIterator, offset uint64, vec ...float32) map[string]string {
+	t.Helper()
+	iter, err := idx.ReadKNN(field, KNNQuery{K: k, Vector: float32Blob(vec...), ScoreField: "dist"}, filter, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[string]string{}
+	last := ""
+	for {
+		occ, _, ok := iter.Next()
+		if !ok {
+			return result
+		}
+		if occ.Doc.Key <= last {
+			t.Fatalf("expected KNN results sorted by key, got %s after %s", occ.Doc.Key, last)
+		}
+		last = occ.Doc.Key
+		result[occ.Doc.Key] = string(occ.Computed["dist"])
+	}
+}
+
+func assertKNN(t *testing.T, actual map[string]string, expected map[string]string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	for key, dist := range expected {
+		if actual[key] != dist {
+			t.Fatalf("expected %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestReadKNNFlat(t *testing.T) {
+	schema := []idxmodel.Field{
+		vectorField("l2", idxmodel.AlgorithmFlat, idxmodel.MetricL2, 2),
+		vectorField("cos", idxmodel.AlgorithmFlat, idxmodel.MetricCosine, 2),
+		{Name: "n", Type: idxmodel.TypeNumeric},
+	}
+	idx, s := newTestIndex(t, schema, map[string]storage.Hash{
+		"doc:1": {"l2": float32Blob(0, 0), "cos": float32Blob(1, 0), "n": []byte("1")},
+		"doc:2": {"l2": float32Blob(1, 0), "cos": float32Blob(10, 1), "n": []byte("2")},
+		"doc:3": {"l2": float32Blob(0, 2), "cos": float32Blob(0, 1), "n": []byte("3")},
+		"doc:4": {"l2": float32Blob(5, 5), "cos": float32Blob(-1, 0), "n": []byte("4")},
+		"doc:5": {"l2": []byte("not a vector"), "n": []byte("5")},
+	})
+
+	// L2 distance is squared as in RediSearch
+	assertKNN(t, readKNN(t, idx, "l2", 2, nil, 1, 0, 0), map[string]string{"doc:1": "0", "doc:2": "1"})
+	assertKNN(t, readKNN(t, idx, "l2", 10, nil, 1, 0, 0),
+		map[string]string{"doc:1": "0", "doc:2": "1", "doc:3": "4", "doc:4": "50"})
+	filter, _ := idx.ReadNumeric("n", NumericRange{Min: 3, Max: math.Inf(1)}, 1)
+	assertKNN(t, readKNN(t, idx, "l2", 1, filter, 1, 0, 0), map[string]string{"doc:3": "4"})
+	// cosine ignores the length of vectors
+	assertKNN(t, readKNN(t, idx, "cos", 2, nil, 1, 2, 0), map[string]string{"doc:1": "0", "doc:2": "0.004962802"})
+
+	s.Begin(2)
+	s.Save("doc:1", storage.Hash{"l2": float32Blob(9, 9)})
+	s.Commit()
+	assertKNN(t, readKNN(t, idx, "l2", 1, nil, 2, 0, 0), map[string]string{"doc:2": "1"})
+	assertKNN(t, readKNN(t, idx, "l2", 1, nil, 2, 9, 9), map[string]string{"doc:1": "0"})
+
+	if _, err := idx.ReadKNN("l2", KNNQuery{K: 1, Vector: float32Blob(0, 0, 0)}, nil, 2); err == nil {
+		t.Error("expected vector of another dimension to be rejected")
+	}
+}
+
+func TestHNSWRecallAgainstFlat(t *testing.T) {
+	const dim = 8
+	schema := []idxmodel.Field{
+		vectorField("flat", idxmodel.AlgorithmFlat, idxmodel.MetricL2, dim),
+		vectorField("hnsw", idxmodel.AlgorithmHNSW, idxmodel.MetricL2, dim),
+		{Name: "parity", Type: idxmodel.TypeTag},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	random := func() []float32 {
+		vec := make([]float32, dim)
+		for i := range vec {
+			vec[i] = rnd.Float32()
+		}
+		return vec
+	}
+	docs := map[string]storage.Hash{}
+	for i := 0; i < 1000; i++ {
+		blob := float32Blob(random()...)
+		docs["doc:"+strconv.Itoa(i)] = storage.Hash{"flat": blob, "hnsw": blob, "parity": []byte(strconv.Itoa(i % 2))}
+	}
+	idx, s := newTestIndex(t, schema, docs)
+
+	const k = 10
+	recall := func(offset uint64, tag string) float64 {
+		found := 0
+		for q := 0; q < 50; q++ {
+			vec := random()
+			var flatFilter, hnswFilter TermIterator
+			if tag != "" {
+				flatFilter, _ = idx.ReadTags("parity", []string{tag}, offset)
+				hnswFilter, _ = idx.ReadTags("parity", []string{tag}, offset)
+			}
+			exact := readKNN(t, idx, "flat", k, flatFilter, offset, vec...)
+			approximate := readKNN(t, idx, "hnsw", k, hnswFilter, offset, vec...)
+			if len(approximate) != k {
+				t.Fatalf("expected %d results, got %d", k, len(approximate))
+			}
+			for key := range approximate {
+				if _, ok := docs[key]; !ok {
+					t.Fatalf("expected only indexed documents, got %s", key)
+				}
+				if _, ok := exact[key]; ok {
+					found++
+				}
+			}
+		}
+		return float64(found) / (50 * k)
+	}
+
+	if r := recall(1, ""); r < 0.9 {
+		t.Errorf("expected recall of at least 0.9, got %v", r)
+	}
+	if r := recall(1, "1"); r < 0.9 {
+		t.Errorf("expected recall of at least 0.9 with the filter, got %v", r)
+	}
+
+	// removed nodes are unlinked from the graph
+	s.Begin(2)
+	for i := 0; i < 1000; i += 3 {
+		key := "doc:" + strconv.Itoa(i)
+		s.Delete(key)
+		delete(docs, key)
+	}
+	s.Commit()
+	if r := recall(2, ""); r < 0.9 {
+		t.Errorf("expected recall of at least 0.9 after removals, got %v", r)
+	}
+}
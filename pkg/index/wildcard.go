@@ -0,0 +1,162 @@
+package index
+
+import (
+	"github.com/bits-and-blooms/bitset"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// wildcardScanLimit bounds the number of words a wildcard query can scan when the suffix trie cannot be used
+const wildcardScanLimit = 100000
+
+var errScanLimit = errors.New("scan limit reached")
+
+type patternRune struct {
+	r        rune
+	wildcard bool // '*' matches any sequence of runes, '?' matches any rune
+}
+
+// wildcardPattern matches words against a pattern of '*' and '?' wildcards, they can be escaped with a backslash
+type wildcardPattern struct {
+	runes []patternRune
+}
+
+func compileWildcard(pattern string) wildcardPattern {
+	runes := make([]patternRune, 0, len(pattern))
+	escaped := false
+	for _, r := range strings.ToLower(pattern) {
+		switch {
+		case escaped:
+			escaped = false
+			runes = append(runes, patternRune{r: r})
+		case r == '\\':
+			escaped = true
+		default:
+			runes = append(runes, patternRune{r: r, wildcard: r == '*' || r == '?'})
+		}
+	}
+	return wildcardPattern{runes: runes}
+}
+
+// prefix returns the literal runes preceding the first wildcard
+func (p wildcardPattern) prefix() string {
+	var b strings.Builder
+	for _, pr := range p.runes {
+		if pr.wildcard {
+			break
+		}
+		b.WriteRune(pr.r)
+	}
+	return b.String()
+}
+
+// longestLiteral returns the longest run of literal runes
+func (p wildcardPattern) longestLiteral() string {
+	longest, current := "", strings.Builder{}
+	for _, pr := range append(p.runes, patternRune{wildcard: true}) {
+		if !pr.wildcard {
+			current.WriteRune(pr.r)
+			continue
+		}
+		if current.Len() > len(longest) {
+			longest = current.String()
+		}
+		current.Reset()
+	}
+	return longest
+}
+
+// match reports whether the whole word matches the pattern, a star is backtracked to when the rest does not match
+func (p wildcardPattern) match(word string) bool {
+	w := []rune(word)
+	pi, wi := 0, 0
+	star, starWi := -1, 0
+	for wi < len(w) {
+		switch {
+		case pi < len(p.runes) && p.runes[pi].wildcard && p.runes[pi].r == '*':
+			star, starWi = pi, wi
+			pi++
+		case pi < len(p.runes) && (p.runes[pi].r == w[wi] || p.runes[pi].wildcard):
+			pi++
+			wi++
+		case star != -1:
+			starWi++
+			pi, wi = star+1, starWi
+		default:
+			return false
+		}
+	}
+	for pi < len(p.runes) && p.runes[pi].wildcard && p.runes[pi].r == '*' {
+		pi++
+	}
+	return pi == len(p.runes)
+}
+
+// ReadWildcard returns iterators over occurrences of up to maxExpansions terms matching the pattern
+// of '*' and '?' wildcards, the pattern is matched against both words and their stems.
+// Patterns starting with a literal prefix are matched against words with the prefix. Otherwise, words containing
+// the longest literal of the pattern are looked up in the suffix trie if all the fields have it,
+// fields are nil for all text fields. When neither applies, up to wildcardScanLimit words are scanned.
+func (i *FTSIndex) ReadWildcard(pattern string, fields *bitset.BitSet, maxExpansions int, snapshot uint64) []TermIterator {
+	p := compileWildcard(pattern)
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	e := i.newExpansion(maxExpansions, snapshot)
+	scanned := 0
+	matchWord := func(word string, term string, occurrences []DocTermOccurrence) error {
+		if scanned++; scanned > wildcardScanLimit {
+			return errScanLimit
+		}
+		if p.match(word) && !e.add(term, occurrences) {
+			return errExpansionFull
+		}
+		return nil
+	}
+	matchForm := func(form string, stem string) bool {
+		return matchWord(form, stem, nil) == nil
+	}
+
+	var err error
+	if prefix := p.prefix(); prefix != "" {
+		err = i.trie.WalkPrefix(prefix, func(term string, occurrences []DocTermOccurrence) error {
+			return matchWord(term, term, occurrences)
+		})
+		if err == nil {
+			i.forms.walk(prefix, matchForm)
+		}
+	} else if literal := p.longestLiteral(); literal != "" && i.coveredBySuffixTrie(fields) {
+		matched := make(map[string]bool)
+		i.suffixes.containing(literal, func(word string, term string) bool {
+			if matched[word] {
+				return true
+			}
+			matched[word] = true
+			return !p.match(word) || e.add(term, nil)
+		})
+	} else {
+		err = i.trie.Walk(func(term string, occurrences []DocTermOccurrence) error {
+			return matchWord(term, term, occurrences)
+		})
+		if err == nil {
+			i.forms.walk("", matchForm)
+		}
+	}
+	if scanned > wildcardScanLimit {
+		log.Debugf("Wildcard %s scanned %d words, the rest is skipped", pattern, wildcardScanLimit)
+	}
+	return e.iters
+}
+
+// coveredBySuffixTrie reports whether all the fields have the suffix trie, nil fields mean all text fields
+func (i *FTSIndex) coveredBySuffixTrie(fields *bitset.BitSet) bool {
+	if i.suffixes == nil {
+		return false
+	}
+	if fields == nil {
+		return i.suffixFields.Count() == uint(len(i.fields))
+	}
+	return i.suffixFields.IsSuperSet(fields)
+}
@@ -0,0 +1,104 @@
+package index
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sort"
+	"testing"
+)
+
+func TestWildcardPatternMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		word    string
+		matches bool
+	}{
+		{"*fix", "suffix", true},
+		{"*fix", "fix", true},
+		{"*fix", "fixes", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hallo", true},
+		{"h?llo", "hllo", false},
+		{"h?llo", "helllo", false},
+		{"*ell*", "bell", true},
+		{"*ell*", "hello", true},
+		{"*ell*", "hel", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "abcbc", true},
+		{"a*b*c", "acb", false},
+		{"H?LLO", "hello", true},
+		{"he\\*", "he*", true},
+		{"he\\*", "hello", false},
+		{"he\\?", "her", false},
+		{"*", "", true},
+		{"?", "", false},
+		{"caf?", "café", true},
+	} {
+		if matches := compileWildcard(c.pattern).match(c.word); matches != c.matches {
+			t.Errorf("expected %q matching %q to be %v", c.pattern, c.word, c.matches)
+		}
+	}
+}
+
+func TestReadWildcard(t *testing.T) {
+	docs := map[string]storage.Hash{
+		"doc:1": {"title": []byte("prefix suffix")},
+		"doc:2": {"title": []byte("hello bell")},
+		"doc:3": {"title": []byte("hallo fixes")},
+		"doc:4": {"title": []byte("running SKU-X42")},
+	}
+	scan, _ := newTestIndex(t, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}, docs)
+	suffixes, s := newTestIndex(t, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText, WithSuffixTrie: true}}, docs)
+
+	for pattern, expected := range map[string][]string{
+		// words are matched together with their stems, fixes is stemmed to fix
+		"*fix":   {"doc:1", "doc:3"},
+		"h?llo":  {"doc:2", "doc:3"},
+		"*ell*":  {"doc:2"},
+		"*unn*":  {"doc:4"},
+		"*42":    {"doc:4"},
+		"hel*":   {"doc:2"},
+		"*x*":    {"doc:1", "doc:3", "doc:4"},
+		"*zzz*":  nil,
+		"?uffix": {"doc:1"},
+	} {
+		for name, idx := range map[string]*FTSIndex{"scan": scan, "suffix trie": suffixes} {
+			keys := make([]string, 0)
+			seen := map[string]bool{}
+			for _, iter := range idx.ReadWildcard(pattern, nil, 100, 1) {
+				for {
+					occ, _, ok := iter.Next()
+					if !ok {
+						break
+					}
+					if !seen[occ.Doc.Key] {
+						seen[occ.Doc.Key] = true
+						keys = append(keys, occ.Doc.Key)
+					}
+				}
+			}
+			sort.Strings(keys)
+			if len(keys) != len(expected) {
+				t.Errorf("%s with %s: expected %v, got %v", pattern, name, expected, keys)
+				continue
+			}
+			for i := range keys {
+				if keys[i] != expected[i] {
+					t.Errorf("%s with %s: expected %v, got %v", pattern, name, expected, keys)
+					break
+				}
+			}
+		}
+	}
+
+	// words of removed versions are dropped from the suffix trie
+	s.Begin(2)
+	s.Save("doc:2", storage.Hash{"title": []byte("goodbye")})
+	s.Commit()
+	if iters := suffixes.ReadWildcard("*ell*", nil, 100, 2); len(iters) != 0 {
+		t.Errorf("expected no words containing ell, got %d", len(iters))
+	}
+	if iters := suffixes.ReadWildcard("*dby*", nil, 100, 2); len(iters) != 1 {
+		t.Errorf("expected goodbye to be found, got %d words", len(iters))
+	}
+}
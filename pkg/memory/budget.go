@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Policy string
+
+const (
+	// RejectCreate rejects new indexes while the budget is exceeded
+	RejectCreate Policy = "reject-create"
+	// DropUnindexed stops storing hash fields that are not indexed by any index while the budget is exceeded
+	DropUnindexed Policy = "drop-unindexed"
+	// EvictUnindexed evicts and stops storing documents outside of index prefixes while the budget is exceeded
+	EvictUnindexed Policy = "evict-unindexed"
+)
+
+// Budget accounts memory used by the storage and indexes against the configured limit.
+// Components report their usage themselves, the budget only sums it up on demand.
+type Budget struct {
+	limit  int64
+	policy Policy
+	usages map[string]func() int64
+	mu     sync.RWMutex
+}
+
+// NewBudget creates a budget with the given limit in bytes, 0 means no limit
+func NewBudget(limit int64, policy Policy) *Budget {
+	return &Budget{limit: limit, policy: policy, usages: map[string]func() int64{}}
+}
+
+func (b *Budget) Limit() int64 {
+	return b.limit
+}
+
+func (b *Budget) Policy() Policy {
+	return b.policy
+}
+
+func (b *Budget) Track(name string, usage func() int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.usages[name] = usage
+}
+
+func (b *Budget) Untrack(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.usages, name)
+}
+
+func (b *Budget) Used() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	used := int64(0)
+	for _, usage := range b.usages {
+		used += usage()
+	}
+	return used
+}
+
+// Usages returns current usage of each tracked component sorted by name
+func (b *Budget) Usages() []Usage {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	usages := make([]Usage, 0, len(b.usages))
+	for name, usage := range b.usages {
+		usages = append(usages, Usage{Name: name, Bytes: usage()})
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Name < usages[j].Name
+	})
+	return usages
+}
+
+func (b *Budget) Exceeded() bool {
+	return b.limit > 0 && b.Used() >= b.limit
+}
+
+// Enforces reports whether the budget is exceeded and the given policy is in effect
+func (b *Budget) Enforces(policy Policy) bool {
+	return b.policy == policy && b.Exceeded()
+}
+
+type Usage struct {
+	Name  string
+	Bytes int64
+}
+
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(s)); p {
+	case RejectCreate, DropUnindexed, EvictUnindexed:
+		return p, nil
+	}
+	return "", errors.Errorf("unknown maxmemory policy '%s', expected one of %s, %s, %s",
+		s, RejectCreate, DropUnindexed, EvictUnindexed)
+}
+
+// ParseSize parses memory size in Redis maxmemory format, e.g. 1048576, 100mb or 2gb
+func ParseSize(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	units := []struct {
+		suffix string
+		mul    int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1}}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid memory size '%s'", size)
+	}
+	return n * mul, nil
+}
+
+// FormatSize formats bytes in the human-readable form used by Redis INFO
+func FormatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return strconv.FormatFloat(float64(bytes)/(1<<30), 'f', 2, 64) + "G"
+	case bytes >= 1<<20:
+		return strconv.FormatFloat(float64(bytes)/(1<<20), 'f', 2, 64) + "M"
+	case bytes >= 1<<10:
+		return strconv.FormatFloat(float64(bytes)/(1<<10), 'f', 2, 64) + "K"
+	}
+	return strconv.FormatInt(bytes, 10) + "B"
+}
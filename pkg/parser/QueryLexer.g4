@@ -8,13 +8,29 @@ OR : '|';
 
 LBRACE : '(';
 RBRACE : ')';
+LBRACKET : '[';
+RBRACKET : ']';
+LCURLY : '{';
+RCURLY : '}';
 QUOTE : '"';
 COLON : ':';
+STAR : '*';
+ARROW : '=>';
+MINUS : '-';
+TILDE : '~';
+SEMI : ';';
 
 FieldIdentifier options { caseInsensitive=false; } : '@' String;
-EscapedString options { caseInsensitive=false; } : '"' (EscapeChar | AnyNonSyntaxChar)*? '"';
-String options { caseInsensitive=false; } : AnyNonSyntaxChar+;
+Param options { caseInsensitive=false; } : '$' String;
+Wildcard options { caseInsensitive=false; } : [wW] '\'' (EscapeChar | ~('\'' | '\\'))* '\'';
+Infix options { caseInsensitive=false; } : Suffix '*';
+Suffix options { caseInsensitive=false; } : '*' (EscapeChar | SuffixStartChar) (EscapeChar | AnyNonSyntaxChar)*;
+Fuzzy options { caseInsensitive=false; } : '%'+ String '%'+;
+Prefix options { caseInsensitive=false; } : String '*';
+String options { caseInsensitive=false; } : (EscapeChar | StringStartChar) (EscapeChar | AnyNonSyntaxChar)*;
 
-fragment AnyNonSyntaxChar : ~(' ' | '(' | ')' | ':' | '"' | '|');
+fragment StringStartChar : ~(' ' | '(' | ')' | '[' | ']' | '{' | '}' | ':' | '"' | '|' | '*' | '$' | '-' | '~' | ';' | '\\');
+fragment SuffixStartChar : ~(' ' | '(' | ')' | '[' | ']' | '{' | '}' | ':' | '"' | '|' | '*' | '$' | '-' | '~' | '=' | ';' | '\\'); // *=> starts KNN
+fragment AnyNonSyntaxChar : ~(' ' | '(' | ')' | '[' | ']' | '{' | '}' | ':' | '"' | '|' | '*' | ';' | '\\');
 
 fragment EscapeChar : '\\' .;
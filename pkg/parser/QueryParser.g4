@@ -4,7 +4,17 @@ options {
   tokenVocab = QueryLexer;
 }
 
-query : query_part EOF;
+query : (query_part | knn_query) EOF;
+
+knn_query : knn_filter ARROW LBRACKET knn_clause RBRACKET;
+
+knn_filter : non_union_query_part;
+
+knn_clause : String knn_value FieldIdentifier Param knn_alias?; // KNN k @field $vector [AS alias]
+
+knn_value : String | Param;
+
+knn_alias : String String;
 
 query_part
   : non_union_query_part
@@ -13,20 +23,70 @@ query_part
 
 non_union_query_part
   : parenthesized_query_part
+  | numeric_query_part
+  | geo_query_part
+  | tag_query_part
   | field_query_part
+  | negated_query_part
+  | optional_query_part
+  | match_all
   | simple_query_part;
 
-field_query_part : field_ref non_union_query_part; // TODO: 02/05/2023 improve parsing by forbidding nested field refs.
-                                                   //       This can be done by having separate cases for parenthesized non-union and parenthesized field-referenced
+field_query_part : field_ref non_union_query_part; // nested field refs override the outer ones
+
+negated_query_part : MINUS non_union_query_part; // -word excludes documents
+
+optional_query_part : TILDE non_union_query_part; // ~word only boosts documents
+
+match_all : STAR; // all documents of the index
+
+numeric_query_part : field_ref numeric_range;
+
+geo_query_part : field_ref geo_radius;
+
+tag_query_part : field_ref tag_list;
 
 simple_query_part
   : word
+  | prefix
+  | suffix
+  | infix
+  | wildcard
+  | fuzzy
   | exact_match;
 
-parenthesized_query_part : LBRACE query_part RBRACE;
+parenthesized_query_part : LBRACE query_part RBRACE attributes?;
+
+attributes : ARROW LCURLY attribute (SEMI attribute)* SEMI? RCURLY; // =>{$slop: 1; $inorder: true}
+
+attribute : Param COLON String;
 
 exact_match : QUOTE word+ QUOTE;
 
-word : String | EscapedString;
+numeric_range : LBRACKET numeric_bound numeric_bound RBRACKET;
+
+numeric_bound : LBRACE? (number | Param); // opening brace marks the bound as exclusive
+
+number : MINUS? String;
+
+geo_radius : LBRACKET number number String String RBRACKET; // lon lat radius unit
+
+tag_list : LCURLY tag (OR tag)* RCURLY;
+
+tag
+  : Param
+  | (String | MINUS | TILDE)+; // tags can contain spaces
+
+word : String | Param; // syntax characters can be escaped with a backslash
+
+prefix : Prefix; // hel*
+
+suffix : Suffix; // *ing
+
+infix : Infix; // *ell*
+
+wildcard : Wildcard; // w'he?lo*'
+
+fuzzy : Fuzzy; // %helo% or %%hlo%% for the edit distance of 1 or 2
 
-field_ref : FieldIdentifier COLON;
+field_ref : FieldIdentifier (OR String)* COLON; // @f1|f2:
@@ -13,7 +13,8 @@ import (
 
 const ftsIndexType = "fts-index"
 
-func Parse(r *bufio.Reader, e exec.Executor) error {
+// Parse loads the RDB snapshot, all its keys are tagged with the replication offset of the snapshot
+func Parse(r *bufio.Reader, e exec.Executor, offset uint64) error {
 	decoder := core.NewDecoder(r).WithSpecialType(ftsIndexType, parseFtsIndex)
 	var procErr error
 	err := decoder.Parse(func(o model.RedisObject) bool {
@@ -23,7 +24,7 @@ func Parse(r *bufio.Reader, e exec.Executor) error {
 			mtObj := o.(*model.ModuleTypeObject)
 			idx := mtObj.Value.(*idxmodel.Index)
 			ftCreate := exec.FtCreateCmd{Index: *idx}
-			err := e.Exec(ftCreate)
+			err := e.Exec(ftCreate, offset)
 			if err != nil {
 				procErr = err
 				return false
@@ -42,7 +43,7 @@ func Parse(r *bufio.Reader, e exec.Executor) error {
 			args[i] = exec.HSetArg{Field: k, Value: v}
 			i++
 		}
-		err := e.Exec(exec.HSetCmd{Key: o.GetKey(), Args: args})
+		err := e.Exec(exec.HSetCmd{Key: o.GetKey(), Args: args}, offset)
 		if err != nil {
 			procErr = err
 			return false
@@ -0,0 +1,84 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/memory"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync/atomic"
+)
+
+func indexUsageName(name string) string {
+	return "index:" + name
+}
+
+// Admit applies the memory budget policy to the fields about to be set on the document.
+// It returns the fields to set, which are stripped of unindexed ones under memory.DropUnindexed policy,
+// or false if the document should not be stored at all under memory.EvictUnindexed policy.
+// Fields already stored are not affected by the policy.
+func (e Engine) Admit(key string, fields storage.Hash) (storage.Hash, bool) {
+	switch {
+	case e.budget.Enforces(memory.DropUnindexed):
+		indexed := e.indexedFields(key)
+		admitted := make(storage.Hash, len(fields))
+		for k, v := range fields {
+			if i := sort.SearchStrings(indexed, k); i < len(indexed) && indexed[i] == k {
+				admitted[k] = v
+			}
+		}
+		return admitted, true
+	case e.budget.Enforces(memory.EvictUnindexed):
+		return fields, e.covers(key)
+	}
+	return fields, true
+}
+
+// EvictUnindexed deletes documents outside of index prefixes once the budget is exceeded
+// under memory.EvictUnindexed policy. New documents are not admitted afterwards, so the storage is scanned
+// again only after the usage drops below the limit and exceeds it one more time.
+func (e Engine) EvictUnindexed() {
+	if !e.budget.Enforces(memory.EvictUnindexed) {
+		if e.budget.Policy() == memory.EvictUnindexed {
+			atomic.StoreInt32(e.evicted, 0)
+		}
+		return
+	}
+	if !atomic.CompareAndSwapInt32(e.evicted, 0, 1) {
+		return
+	}
+
+	evicted := 0
+	for _, d := range e.s.GetAll([]string{"*"}) {
+		if !e.covers(d.Key) {
+			e.s.Delete(d.Key)
+			evicted++
+		}
+	}
+	log.Warnf("Memory budget of %s exceeded, evicted %d documents outside of index prefixes",
+		memory.FormatSize(e.budget.Limit()), evicted)
+}
+
+func (e Engine) covers(key string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, idx := range e.indexes {
+		if idx.Covers(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexedFields returns sorted names of fields indexed by any index covering the key
+func (e Engine) indexedFields(key string) []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	fields := make([]string, 0)
+	for _, idx := range e.indexes {
+		if idx.Covers(key) {
+			fields = append(fields, idx.Fields()...)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
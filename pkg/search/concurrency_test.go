@@ -0,0 +1,114 @@
+package search
+
+import (
+	"fmt"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/memory"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentReplicationAndQueries runs replication writes, garbage collection, index creation and queries
+// at once, it is meant to be run with the race detector. Every query must see a consistent snapshot.
+func TestConcurrentReplicationAndQueries(t *testing.T) {
+	s := storage.NewMemory()
+	e := NewEngine(s, memory.NewBudget(1<<30, memory.EvictUnindexed), DefaultConfig())
+	schema := []idxmodel.Field{
+		{Name: "title", Type: idxmodel.TypeText},
+		{Name: "n", Type: idxmodel.TypeNumeric},
+		{Name: "cat", Type: idxmodel.TypeTag, Separator: ","},
+	}
+	hash := func(k int, words string) storage.Hash {
+		return storage.Hash{
+			"title": []byte(fmt.Sprintf("hello world %s %d", words, k%7)),
+			"n":     []byte(fmt.Sprint(k % 100)),
+			"cat":   []byte(fmt.Sprintf("c%d", k%3)),
+		}
+	}
+
+	const docs = 300
+	for k := 0; k < docs; k++ {
+		s.Begin(uint64(k + 1))
+		s.Save(fmt.Sprintf("doc:%d", k), hash(k, "first"))
+		s.Commit()
+	}
+	if err := e.CreateIndex(testIndex, []string{"doc:"}, schema); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for k := 0; k < 2000; k++ {
+			s.Begin(uint64(docs + 1 + k))
+			s.Save(fmt.Sprintf("doc:%d", k%docs), hash(k, "again"))
+			if k%3 == 0 {
+				s.Delete(fmt.Sprintf("doc:%d", (k+7)%docs))
+			}
+			s.Commit()
+		}
+	}()
+
+	queries := []func(idx *index.FTSIndex, snapshot uint64) index.TermIterator{
+		func(idx *index.FTSIndex, snapshot uint64) index.TermIterator {
+			return Intersect(Union(idx.Read("first", snapshot), idx.Read("again", snapshot)), idx.Read("world", snapshot))
+		},
+		func(idx *index.FTSIndex, snapshot uint64) index.TermIterator {
+			return Not(idx.Read("again", snapshot), idx.ReadAll(snapshot))
+		},
+		func(idx *index.FTSIndex, snapshot uint64) index.TermIterator {
+			return UnionAll(idx.ReadPrefix("agai", 10, snapshot))
+		},
+		func(idx *index.FTSIndex, snapshot uint64) index.TermIterator {
+			iter, err := idx.ReadNumeric("n", index.NumericRange{Min: 10, Max: 50}, snapshot)
+			if err != nil {
+				t.Error(err)
+				return index.Empty()
+			}
+			return Intersect(idx.Read("hello", snapshot), iter)
+		},
+		func(idx *index.FTSIndex, snapshot uint64) index.TermIterator {
+			return Proximity([]index.TermIterator{idx.Read("hello", snapshot), idx.Read("world", snapshot)}, 0, true)
+		},
+	}
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for k := 0; k < 500; k++ {
+				snapshot := s.Snapshot()
+				e.mu.RLock()
+				idx := e.indexes[testIndex]
+				e.mu.RUnlock()
+
+				query := queries[(r+k)%len(queries)]
+				page := TopN(0, 20, query(idx, snapshot.Offset), nil)
+				seen := make(map[string]bool)
+				for {
+					occ, _, ok := page.Next()
+					if !ok {
+						break
+					}
+					if !occ.Doc.VisibleAt(snapshot.Offset) {
+						t.Errorf("document %s version %d is not visible at %d", occ.Doc.Key, occ.Doc.Version, snapshot.Offset)
+					}
+					if seen[occ.Doc.Key] {
+						t.Errorf("document %s is returned twice", occ.Doc.Key)
+					}
+					seen[occ.Doc.Key] = true
+					if hash, err := s.Load(occ.Doc); err != nil || hash == nil {
+						t.Errorf("failed to load document %s: %v", occ.Doc.Key, err)
+					}
+				}
+				_ = idx.Stats(5)
+				_ = idx.MemoryUsage()
+				snapshot.Release()
+			}
+		}(r)
+	}
+	wg.Wait()
+}
@@ -3,29 +3,73 @@ package search
 import (
 	"fmt"
 	"github.com/antlr4-go/antlr/v4"
-	"github.com/blevesearch/go-porterstemmer"
+	"github.com/bits-and-blooms/bitset"
 	"github.com/emirpasic/gods/stacks"
 	"github.com/emirpasic/gods/stacks/arraystack"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
 	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/memory"
 	"github.com/kuzznya/go-redis-search-replica/pkg/parser"
 	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"math"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 const indexAsync = false
 
+// maxFuzzyDistance is the maximum Levenshtein distance of fuzzy terms
+const maxFuzzyDistance = 2
+
+const (
+	DefaultMinPrefix     = 2
+	DefaultMaxExpansions = 200
+	DefaultDialect       = 1
+	// MaxDialect is the latest query dialect, dialect 2 allows parameters in terms, numeric bounds and tags
+	MaxDialect = 2
+)
+
+// Config tunes query execution
+type Config struct {
+	// MinPrefix is the minimum length of prefix queries
+	MinPrefix int
+	// MaxExpansions limits the number of terms a prefix query is expanded to
+	MaxExpansions int
+	// DefaultDialect is the dialect of queries without DIALECT option
+	DefaultDialect int
+}
+
+// DefaultConfig returns the configuration used when none is provided
+func DefaultConfig() Config {
+	return Config{MinPrefix: DefaultMinPrefix, MaxExpansions: DefaultMaxExpansions, DefaultDialect: DefaultDialect}
+}
+
+// Engine owns the indexes and keeps them in sync with the storage. Indexes are updated synchronously by
+// the replication stream and asynchronously by the index loader and the garbage collector of reclaimed
+// document versions, see index.FTSIndex for how these writers are serialized and how queries read concurrently.
 type Engine struct {
 	s       storage.Storage
+	budget  *memory.Budget
+	cfg     Config
 	indexes map[string]*index.FTSIndex
+	evicted *int32 // set when documents outside index prefixes were evicted after exceeding the budget
 	mu      *sync.RWMutex
 }
 
-func NewEngine(s storage.Storage) Engine {
-	e := Engine{s: s, indexes: make(map[string]*index.FTSIndex), mu: &sync.RWMutex{}}
+func NewEngine(s storage.Storage, budget *memory.Budget, cfg Config) Engine {
+	e := Engine{
+		s:       s,
+		budget:  budget,
+		cfg:     cfg,
+		indexes: make(map[string]*index.FTSIndex),
+		evicted: new(int32),
+		mu:      &sync.RWMutex{},
+	}
 
 	newDocs := make(chan *storage.Document)
 
@@ -45,20 +89,29 @@ func NewEngine(s storage.Storage) Engine {
 
 	go func() {
 		for {
-			_ = <-deletedDocs
-			// TODO 20.03.2023 implement GC
+			d := <-deletedDocs
+			e.remove(d)
 		}
 	}()
 
 	return e
 }
 
-func (e Engine) CreateIndex(name string, prefixes []string, fields []string) {
-	idx := index.NewFTSIndex(prefixes, fields)
+func (e Engine) CreateIndex(name string, prefixes []string, schema []idxmodel.Field) error {
+	if e.budget.Enforces(memory.RejectCreate) {
+		return errors.Errorf("memory budget of %s exceeded, index %s is rejected",
+			memory.FormatSize(e.budget.Limit()), name)
+	}
+
+	idx := index.NewFTSIndex(e.s, prefixes, schema)
 
 	e.mu.Lock()
 	e.indexes[name] = idx
 	e.mu.Unlock()
+	e.budget.Track(indexUsageName(name), idx.MemoryUsage)
+	for _, prefix := range prefixes {
+		e.s.TrackPrefix(prefix)
+	}
 
 	log.Infof("Created index %s", name)
 	start := time.Now()
@@ -68,33 +121,73 @@ func (e Engine) CreateIndex(name string, prefixes []string, fields []string) {
 		idx.Load(docs)
 		log.Infof("Index %s creation finished in %s", name, time.Now().Sub(start))
 	}()
+	return nil
 }
 
 func (e Engine) DeleteIndex(name string) {
-	e.mu.RLock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	if i, ok := e.indexes[name]; ok {
 		i.MarkDeleted()
-	} else {
-		return
+		delete(e.indexes, name)
+		e.budget.Untrack(indexUsageName(name))
+		for _, prefix := range i.Prefixes() {
+			e.s.UntrackPrefix(prefix)
+		}
 	}
-	e.mu.RUnlock()
-	e.mu.Lock()
-	delete(e.indexes, name)
-	e.mu.Unlock()
 }
 
 func (e Engine) Add(d *storage.Document) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	for _, idx := range e.indexes {
 		idx.Add(d)
 	}
 }
 
+// remove drops the reclaimed document version from all indexes
+func (e Engine) remove(d *storage.Document) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, idx := range e.indexes {
+		idx.Remove(d)
+	}
+}
+
 type Limit struct {
 	Offset int
 	Num    int
 }
 
-func (e Engine) Search(idxName string, query string, limit *Limit) (iter index.TermIterator, err error) {
+// GeoFilter limits results to documents with the point of the geo field within the radius
+type GeoFilter struct {
+	Field string
+	index.GeoRadius
+}
+
+// Options are FT.SEARCH arguments besides the index and the query
+type Options struct {
+	Limit      *Limit
+	GeoFilters []GeoFilter
+	Params     map[string]string
+	Hybrid     *Hybrid
+	// Slop is the maximum number of tokens between the intersected terms, nil for any number
+	Slop *int
+	// InOrder requires the intersected terms to occur in the query order
+	InOrder bool
+	// Dialect is the version of the query syntax, 0 for the default one
+	Dialect int
+	// ExplainScore makes the returned occurrences explain their scores
+	ExplainScore bool
+	// SortBy orders the results by the sortable field, nil orders them by score
+	SortBy *SortBy
+	// Highlight wraps the matched tokens of the returned documents, nil returns them as is
+	Highlight *Highlight
+}
+
+// Search runs the query against document versions visible at the snapshot and returns the requested page.
+// The snapshot should be held until the returned documents are read from storage.
+func (e Engine) Search(idxName string, query string, opts Options, snapshot *storage.Snapshot) (page *TopNIterator, err error) {
 	e.mu.RLock()
 	idx, found := e.indexes[idxName]
 	e.mu.RUnlock()
@@ -102,8 +195,6 @@ func (e Engine) Search(idxName string, query string, limit *Limit) (iter index.T
 		return nil, errors.Errorf("Index %s not found", idxName)
 	}
 
-	ftSearch := newQueryListener(idx)
-
 	defer func() {
 		r := recover()
 		if r == nil {
@@ -116,6 +207,44 @@ func (e Engine) Search(idxName string, query string, limit *Limit) (iter index.T
 		}
 	}()
 
+	var iter index.TermIterator
+	if opts.Hybrid != nil {
+		iter, err = e.hybridSearch(idx, query, opts, snapshot.Offset)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		iter = e.parseQuery(idx, query, snapshot.Offset, opts)
+		if iter, err = filterGeo(idx, iter, opts.GeoFilters, snapshot.Offset); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.SortBy != nil {
+		if iter, err = idx.SortBy(opts.SortBy.Field, iter); err != nil {
+			return nil, err
+		}
+	}
+	var h *highlighter
+	if opts.Highlight != nil {
+		if h, err = newHighlighter(idx, *opts.Highlight); err != nil {
+			return nil, err
+		}
+	}
+
+	if limit := opts.Limit; limit != nil {
+		page = TopN(limit.Offset, limit.Num, iter, opts.SortBy)
+	} else {
+		page = TopN(0, math.MaxInt, iter, opts.SortBy)
+	}
+	page.highlight = h
+	return page, nil
+}
+
+// parseQuery builds iterator over the query results, it panics if the query is invalid
+func (e Engine) parseQuery(idx *index.FTSIndex, query string, snapshot uint64, opts Options) index.TermIterator {
+	ftSearch := newQueryListener(idx, snapshot, opts, e.cfg)
+
 	lexerErrors := сustomErrorListener{}
 	parserErrors := сustomErrorListener{}
 
@@ -135,40 +264,363 @@ func (e Engine) Search(idxName string, query string, limit *Limit) (iter index.T
 
 	iter, ok := ftSearch.pop()
 	if !ok {
-		return nil, errors.New("failed to parse query")
+		panic(errors.New("failed to parse query"))
 	}
+	return iter
+}
 
-	if limit != nil {
-		iter = TopN(limit.Offset, limit.Num, iter)
-	} else {
-		iter = TopN(0, math.MaxInt, iter)
+func filterGeo(idx *index.FTSIndex, iter index.TermIterator, filters []GeoFilter, snapshot uint64) (index.TermIterator, error) {
+	for _, f := range filters {
+		geoIter, err := idx.ReadGeo(f.Field, f.GeoRadius, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		iter = Filter(iter, geoIter)
 	}
-
 	return iter, nil
 }
 
 type queryListener struct {
 	*parser.BaseQueryParserListener
-	idx   *index.FTSIndex
-	stack stacks.Stack
+	idx      *index.FTSIndex
+	snapshot uint64
+	params   map[string]string
+	dialect  int
+	explain  bool
+	cfg      Config
+	stack    stacks.Stack
+	// fields of the enclosing field-scoped query parts, the innermost is the last
+	fields []*bitset.BitSet
+	// proximity constraints of the query and of the enclosing query parts with attributes, the innermost is the last
+	proximities []proximity
+	// weights of the enclosing query parts with attributes, the innermost is the last
+	weights []float32
+}
+
+// proximity constrains positions of the intersected terms
+type proximity struct {
+	slop    int // -1 for any number of intervening tokens
+	inOrder bool
+}
+
+func (p proximity) enabled() bool {
+	return p.slop >= 0 || p.inOrder
 }
 
-func newQueryListener(idx *index.FTSIndex) *queryListener {
-	return &queryListener{idx: idx, stack: arraystack.New()}
+func newQueryListener(idx *index.FTSIndex, snapshot uint64, opts Options, cfg Config) *queryListener {
+	p := proximity{slop: -1, inOrder: opts.InOrder}
+	if opts.Slop != nil {
+		p.slop = *opts.Slop
+	}
+	dialect := opts.Dialect
+	if dialect == 0 {
+		dialect = cfg.DefaultDialect
+	}
+	return &queryListener{idx: idx, snapshot: snapshot, params: opts.Params, dialect: dialect, explain: opts.ExplainScore,
+		cfg: cfg, stack: arraystack.New(), proximities: []proximity{p}}
+}
+
+func (l *queryListener) param(token antlr.TerminalNode) string {
+	name := strings.TrimPrefix(token.GetText(), "$")
+	value, ok := l.params[name]
+	if !ok {
+		panic(errors.Errorf("No such parameter `%s`", name))
+	}
+	return value
+}
+
+// queryParam returns the value of the parameter used in place of a term, numeric bound or tag.
+// Before DIALECT 2 the parameter is read literally, as $ was an ordinary character of terms.
+func (l *queryListener) queryParam(token antlr.TerminalNode) string {
+	if l.dialect < 2 {
+		return unescape(token.GetText())
+	}
+	return l.param(token)
 }
 
 func (l *queryListener) ExitWord(ctx *parser.WordContext) {
-	word := ctx.GetText()
-	term := porterstemmer.StemString(word)
-	l.stack.Push(l.idx.Read(term))
+	// phrase words are read together when the phrase is exited
+	if _, ok := ctx.GetParent().(*parser.Exact_matchContext); ok {
+		return
+	}
+	l.stack.Push(l.scope(l.term(l.idx.Read(l.word(ctx), l.snapshot))))
+}
+
+// word returns the word or the value of the parameter used instead of it
+func (l *queryListener) word(ctx parser.IWordContext) string {
+	if ctx.Param() != nil {
+		return l.queryParam(ctx.Param())
+	}
+	return unescape(ctx.GetText())
+}
+
+func (l *queryListener) ExitPrefix(ctx *parser.PrefixContext) {
+	prefix := strings.TrimSuffix(unescape(ctx.GetText()), "*")
+	l.checkLength(prefix)
+	l.stack.Push(l.scope(UnionAll(l.terms(l.idx.ReadPrefix(prefix, l.cfg.MaxExpansions, l.snapshot)))))
+}
+
+func (l *queryListener) ExitSuffix(ctx *parser.SuffixContext) {
+	suffix := unescape(strings.TrimPrefix(ctx.GetText(), "*"))
+	l.checkLength(suffix)
+	l.readWildcard("*" + escapeWildcard(suffix))
+}
+
+func (l *queryListener) ExitInfix(ctx *parser.InfixContext) {
+	infix := unescape(strings.TrimSuffix(strings.TrimPrefix(ctx.GetText(), "*"), "*"))
+	l.checkLength(infix)
+	l.readWildcard("*" + escapeWildcard(infix) + "*")
+}
+
+func (l *queryListener) ExitWildcard(ctx *parser.WildcardContext) {
+	text := ctx.GetText()
+	l.readWildcard(text[2 : len(text)-1])
+}
+
+func (l *queryListener) readWildcard(pattern string) {
+	var fields *bitset.BitSet
+	if len(l.fields) > 0 {
+		fields = l.fields[len(l.fields)-1]
+	}
+	l.stack.Push(l.scope(UnionAll(l.terms(l.idx.ReadWildcard(pattern, fields, l.cfg.MaxExpansions, l.snapshot)))))
+}
+
+// checkLength rejects prefixes, suffixes and infixes shorter than the minimum prefix length
+func (l *queryListener) checkLength(s string) {
+	if utf8.RuneCountInString(s) < l.cfg.MinPrefix {
+		panic(errors.Errorf("`%s` is shorter than the minimum prefix length %d", s, l.cfg.MinPrefix))
+	}
+}
+
+func (l *queryListener) ExitFuzzy(ctx *parser.FuzzyContext) {
+	text := ctx.GetText()
+	word := strings.TrimLeft(text, "%")
+	distance := len(text) - len(word)
+	trimmed := strings.TrimRight(word, "%")
+	if len(word)-len(trimmed) != distance || distance > maxFuzzyDistance {
+		panic(errors.Errorf("Bad fuzzy term %s, expected up to %d percent signs on both sides", text, maxFuzzyDistance))
+	}
+	iters := l.idx.ReadFuzzy(unescape(trimmed), distance, l.cfg.MaxExpansions, l.snapshot)
+	l.stack.Push(l.scope(UnionAll(l.terms(iters))))
+}
+
+// term makes the term iterator explain scores if the query explains them
+func (l *queryListener) term(iter index.TermIterator) index.TermIterator {
+	if !l.explain {
+		return iter
+	}
+	return ExplainTerm(iter)
+}
+
+func (l *queryListener) terms(iters []index.TermIterator) []index.TermIterator {
+	for i := range iters {
+		iters[i] = l.term(iters[i])
+	}
+	return iters
+}
+
+// scope limits the iterator to the fields of the current field-scoped query part
+func (l *queryListener) scope(iter index.TermIterator) index.TermIterator {
+	if len(l.fields) == 0 {
+		return iter
+	}
+	return Fields(iter, l.fields[len(l.fields)-1])
 }
 
 func (l *queryListener) ExitExact_match(ctx *parser.Exact_matchContext) {
-	panic(errors.New("not implemented"))
+	words := ctx.AllWord()
+	iters := make([]index.TermIterator, len(words))
+	offsets := make([]int, len(words))
+	for i, word := range words {
+		iters[i] = l.scope(l.term(l.idx.Read(l.word(word), l.snapshot)))
+		offsets[i] = i
+	}
+	l.stack.Push(Phrase(iters, offsets))
+}
+
+func (l *queryListener) ExitKnn_query(ctx *parser.Knn_queryContext) {
+	filter, ok := l.pop()
+	if !ok {
+		panic(errors.Errorf("Failed to parse KNN filter: %s", ctx.Knn_filter().GetText()))
+	}
+	if _, ok := filter.(*index.AllIterator); ok {
+		filter = nil
+	}
+
+	clause := ctx.Knn_clause()
+	if strings.ToLower(clause.String_().GetText()) != "knn" {
+		panic(errors.Errorf("Expected KNN, got %s", clause.String_().GetText()))
+	}
+	kStr := clause.Knn_value().GetText()
+	if clause.Knn_value().Param() != nil {
+		kStr = l.param(clause.Knn_value().Param())
+	}
+	k, err := strconv.Atoi(kStr)
+	if err != nil || k < 0 {
+		panic(errors.Errorf("KNN requires a non-negative number of neighbours, got %s", kStr))
+	}
+	field := strings.TrimPrefix(clause.FieldIdentifier().GetText(), "@")
+	scoreField := fmt.Sprintf("__%s_score", field)
+	if alias := clause.Knn_alias(); alias != nil {
+		if strings.ToLower(alias.String_(0).GetText()) != "as" {
+			panic(errors.Errorf("Expected AS, got %s", alias.String_(0).GetText()))
+		}
+		scoreField = alias.String_(1).GetText()
+	}
+
+	q := index.KNNQuery{K: k, Vector: []byte(l.param(clause.Param())), ScoreField: scoreField}
+	iter, err := l.idx.ReadKNN(field, q, filter, l.snapshot)
+	if err != nil {
+		panic(err)
+	}
+	l.stack.Push(iter)
+}
+
+func (l *queryListener) ExitNumeric_query_part(ctx *parser.Numeric_query_partContext) {
+	field := fieldName(ctx.Field_ref())
+	bounds := ctx.Numeric_range().AllNumeric_bound()
+	min, err := parseNumericBound(l.numericBound(bounds[0]))
+	if err != nil {
+		panic(err)
+	}
+	max, err := parseNumericBound(l.numericBound(bounds[1]))
+	if err != nil {
+		panic(err)
+	}
+	r := index.NumericRange{Min: min, MinExclusive: bounds[0].LBRACE() != nil, Max: max, MaxExclusive: bounds[1].LBRACE() != nil}
+	iter, err := l.idx.ReadNumeric(field, r, l.snapshot)
+	if err != nil {
+		panic(err)
+	}
+	l.stack.Push(iter)
+}
+
+func (l *queryListener) ExitGeo_query_part(ctx *parser.Geo_query_partContext) {
+	field := fieldName(ctx.Field_ref())
+	coords := ctx.Geo_radius().AllNumber()
+	args := ctx.Geo_radius().AllString_()
+	r, err := index.ParseGeoRadius(coords[0].GetText(), coords[1].GetText(), args[0].GetText(), args[1].GetText())
+	if err != nil {
+		panic(err)
+	}
+	iter, err := l.idx.ReadGeo(field, r, l.snapshot)
+	if err != nil {
+		panic(err)
+	}
+	l.stack.Push(iter)
+}
+
+func (l *queryListener) ExitTag_query_part(ctx *parser.Tag_query_partContext) {
+	field := fieldName(ctx.Field_ref())
+	tagCtxs := ctx.Tag_list().AllTag()
+	tags := make([]string, len(tagCtxs))
+	for i, tag := range tagCtxs {
+		if tag.Param() != nil {
+			tags[i] = l.queryParam(tag.Param())
+			continue
+		}
+		// hidden spaces between the tag words are kept by reading the original input
+		tags[i] = tag.GetStart().GetInputStream().GetText(tag.GetStart().GetStart(), tag.GetStop().GetStop())
+	}
+	iter, err := l.idx.ReadTags(field, tags, l.snapshot)
+	if err != nil {
+		panic(err)
+	}
+	l.stack.Push(iter)
+}
+
+func (l *queryListener) EnterField_query_part(ctx *parser.Field_query_partContext) {
+	fields, err := l.idx.TextFields(fieldNames(ctx.Field_ref()))
+	if err != nil {
+		panic(err)
+	}
+	l.fields = append(l.fields, fields)
 }
 
 func (l *queryListener) ExitField_query_part(ctx *parser.Field_query_partContext) {
-	panic(errors.New("not implemented"))
+	l.fields = l.fields[:len(l.fields)-1]
+}
+
+func (l *queryListener) ExitMatch_all(ctx *parser.Match_allContext) {
+	l.stack.Push(l.idx.ReadAll(l.snapshot))
+}
+
+func (l *queryListener) ExitNegated_query_part(ctx *parser.Negated_query_partContext) {
+	iter, ok := l.pop()
+	if !ok {
+		panic(errors.Errorf("Failed to parse negated query part: %s", ctx.GetText()))
+	}
+	l.stack.Push(Not(iter, l.idx.ReadAll(l.snapshot)))
+}
+
+func (l *queryListener) ExitOptional_query_part(ctx *parser.Optional_query_partContext) {
+	iter, ok := l.pop()
+	if !ok {
+		panic(errors.Errorf("Failed to parse optional query part: %s", ctx.GetText()))
+	}
+	l.stack.Push(Optional(l.idx.ReadAll(l.snapshot), iter))
+}
+
+func (l *queryListener) EnterParenthesized_query_part(ctx *parser.Parenthesized_query_partContext) {
+	if ctx.Attributes() == nil {
+		return
+	}
+	p := l.proximities[len(l.proximities)-1]
+	weight := float32(1)
+	for _, attr := range ctx.Attributes().AllAttribute() {
+		name := strings.ToLower(strings.TrimPrefix(attr.Param().GetText(), "$"))
+		value := attr.String_().GetText()
+		switch name {
+		case "slop":
+			slop, err := strconv.Atoi(value)
+			if err != nil || slop < 0 {
+				panic(errors.Errorf("$slop requires a non-negative number, got %s", value))
+			}
+			p.slop = slop
+		case "inorder":
+			inOrder, err := strconv.ParseBool(value)
+			if err != nil {
+				panic(errors.Errorf("$inorder requires true or false, got %s", value))
+			}
+			p.inOrder = inOrder
+		case "weight":
+			w, err := strconv.ParseFloat(value, 32)
+			if err != nil || w < 0 || math.IsNaN(w) || math.IsInf(w, 1) {
+				panic(errors.Errorf("$weight requires a non-negative number, got %s", value))
+			}
+			weight = float32(w)
+		case "phonetic":
+			phonetic, err := strconv.ParseBool(value)
+			if err != nil {
+				panic(errors.Errorf("$phonetic requires true or false, got %s", value))
+			}
+			// fields are never indexed phonetically, so only disabling phonetic matching is valid
+			if phonetic {
+				panic(errors.New("$phonetic is not supported, no field has phonetic matching"))
+			}
+		default:
+			panic(errors.Errorf("Unknown attribute $%s", name))
+		}
+	}
+	l.proximities = append(l.proximities, p)
+	l.weights = append(l.weights, weight)
+}
+
+func (l *queryListener) ExitParenthesized_query_part(ctx *parser.Parenthesized_query_partContext) {
+	if ctx.Attributes() == nil {
+		return
+	}
+	l.proximities = l.proximities[:len(l.proximities)-1]
+	weight := l.weights[len(l.weights)-1]
+	l.weights = l.weights[:len(l.weights)-1]
+	if weight == 1 {
+		return
+	}
+	iter, ok := l.pop()
+	if !ok {
+		panic(errors.Errorf("Failed to parse query part: %s", ctx.GetText()))
+	}
+	l.stack.Push(Weight(iter, weight))
 }
 
 func (l *queryListener) ExitQuery_part(ctx *parser.Query_partContext) {
@@ -191,6 +643,68 @@ func (l *queryListener) ExitQuery_part(ctx *parser.Query_partContext) {
 	return
 }
 
+// unescape removes backslashes escaping syntax characters
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// escapeWildcard escapes wildcards and backslashes, so that the string is matched literally
+func escapeWildcard(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "*", "\\*", "?", "\\?").Replace(s)
+}
+
+func fieldNames(ctx parser.IField_refContext) []string {
+	names := []string{strings.TrimPrefix(ctx.FieldIdentifier().GetText(), "@")}
+	for _, name := range ctx.AllString_() {
+		names = append(names, name.GetText())
+	}
+	return names
+}
+
+// fieldName returns the referenced field of numeric, tag or geo query parts, which cannot be multi-field
+func fieldName(ctx parser.IField_refContext) string {
+	names := fieldNames(ctx)
+	if len(names) > 1 {
+		panic(errors.Errorf("Only text query parts can reference multiple fields: %s", ctx.GetText()))
+	}
+	return names[0]
+}
+
+// numericBound returns the text of the bound or the value of the parameter used instead of it
+func (l *queryListener) numericBound(ctx parser.INumeric_boundContext) string {
+	if ctx.Param() != nil {
+		return l.queryParam(ctx.Param())
+	}
+	return ctx.Number().GetText()
+}
+
+func parseNumericBound(text string) (float64, error) {
+	switch strings.ToLower(text) {
+	case "-inf":
+		return math.Inf(-1), nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, errors.Errorf("Bad numeric range bound %s", text)
+	}
+	return value, nil
+}
+
 func (l *queryListener) union() bool {
 	iter2, ok := l.pop()
 	if !ok {
@@ -217,12 +731,49 @@ func (l *queryListener) intersect() bool {
 		return false
 	}
 
-	iter1 = Intersect(iter1, iter2)
-
-	l.stack.Push(iter1)
+	if iter, ok := narrow(iter1, iter2); ok {
+		l.stack.Push(iter)
+	} else if iter, ok := narrow(iter2, iter1); ok {
+		l.stack.Push(iter)
+	} else if p := l.proximities[len(l.proximities)-1]; p.enabled() {
+		l.stack.Push(l.proximity(iter1, iter2, p))
+	} else {
+		l.stack.Push(Intersect(iter1, iter2))
+	}
 	return true
 }
 
+// proximity intersects the iterators with the proximity constraint,
+// the left-nested intersections of the same query part are flattened into one
+func (l *queryListener) proximity(iter1 index.TermIterator, iter2 index.TermIterator, p proximity) index.TermIterator {
+	if prox, ok := iter1.(*ProximityIterator); ok && prox.slop == p.slop && prox.inOrder == p.inOrder {
+		iters := make([]index.TermIterator, 0, len(prox.iters)+1)
+		return Proximity(append(append(iters, prox.iters...), iter2), p.slop, p.inOrder)
+	}
+	return Proximity([]index.TermIterator{iter1, iter2}, p.slop, p.inOrder)
+}
+
+// narrow replaces all documents of the match-all, negated or optional query part with the other part
+// of the intersection, so that the intersection is computed without reading all documents of the index
+func narrow(iter index.TermIterator, other index.TermIterator) (index.TermIterator, bool) {
+	if _, ok := other.(index.StopWordIterator); ok {
+		return nil, false
+	}
+	switch it := iter.(type) {
+	case *index.AllIterator:
+		return other, true
+	case *NotIterator:
+		if _, ok := it.universe.(*index.AllIterator); ok {
+			return Not(it.iter, other), true
+		}
+	case *OptionalIterator:
+		if _, ok := it.base.(*index.AllIterator); ok {
+			return Optional(other, it.optional), true
+		}
+	}
+	return nil, false
+}
+
 func (l *queryListener) pop() (index.TermIterator, bool) {
 	v, ok := l.stack.Pop()
 	if !ok {
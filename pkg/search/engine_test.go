@@ -0,0 +1,403 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyDistanceIsCapped(t *testing.T) {
+	e, s := newTestEngine(t, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello")},
+		"doc:2": {"title": []byte("world")},
+	})
+
+	for _, query := range []string{"%helo%", "%%hlo%%"} {
+		page, err := search(t, e, s, query, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		assertKeys(t, page, "doc:1")
+	}
+	if _, err := search(t, e, s, "%%%hlo%%%", Options{}); err == nil {
+		t.Error("expected fuzzy distance 3 to be rejected")
+	}
+}
+
+func TestParamsByDialect(t *testing.T) {
+	schema := []idxmodel.Field{
+		{Name: "title", Type: idxmodel.TypeText},
+		{Name: "price", Type: idxmodel.TypeNumeric},
+		{Name: "cat", Type: idxmodel.TypeTag, Separator: ","},
+	}
+	e, s := newTestEngine(t, schema, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello world"), "price": []byte("10"), "cat": []byte("news")},
+		"doc:2": {"title": []byte("hello there"), "price": []byte("20"), "cat": []byte("sport")},
+		"doc:3": {"title": []byte("goodbye"), "price": []byte("30"), "cat": []byte("news")},
+		"doc:4": {"title": []byte("pay in $"), "cat": []byte("$cat")},
+	})
+	params := map[string]string{"term": "hello", "min": "15", "cat": "news"}
+
+	for query, expected := range map[string][]string{
+		"$term":                             {"doc:1", "doc:2"},
+		"@price:[$min +inf]":                {"doc:2", "doc:3"},
+		"@cat:{$cat}":                       {"doc:1", "doc:3"},
+		"$term @cat:{$cat} @price:[0 $min]": {"doc:1"},
+	} {
+		page, err := search(t, e, s, query, Options{Params: params, Dialect: 2})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		assertKeys(t, page, expected...)
+	}
+	if _, err := search(t, e, s, "$missing", Options{Params: params, Dialect: 2}); err == nil {
+		t.Error("expected unknown parameter to be rejected")
+	}
+
+	// parameters are read literally before DIALECT 2, as they were before parameters were supported
+	for _, dialect := range []int{0, 1} {
+		for query, expected := range map[string][]string{
+			"$term":       {},
+			"@cat:{$cat}": {"doc:4"},
+			"$missing":    {},
+		} {
+			page, err := search(t, e, s, query, Options{Params: params, Dialect: dialect})
+			if err != nil {
+				t.Fatalf("%s in DIALECT %d: %v", query, dialect, err)
+			}
+			assertKeys(t, page, expected...)
+		}
+	}
+}
+
+func TestPhrase(t *testing.T) {
+	schema := []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}, {Name: "body", Type: idxmodel.TypeText}}
+	e, s := newTestEngine(t, schema, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello world")},
+		"doc:2": {"title": []byte("world hello")},
+		"doc:3": {"title": []byte("hello big world")},
+		"doc:4": {"title": []byte("hello"), "body": []byte("world")},
+		"doc:5": {"title": []byte("the end of the world")},
+	})
+
+	for query, expected := range map[string][]string{
+		`"hello world"`:     {"doc:1"},
+		`"world hello"`:     {"doc:2"},
+		`"hello big world"`: {"doc:3"},
+		// stop words are not indexed, but keep their positions
+		`"end of the world"`: {"doc:5"},
+		`"end the world"`:    {},
+		`"the"`:              {},
+		`"hello world" big`:  {},
+		`"big world" hello`:  {"doc:3"},
+	} {
+		page, err := search(t, e, s, query, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		assertKeys(t, page, expected...)
+	}
+}
+
+// assertKeySet checks the matched documents regardless of their order
+func assertKeySet(t *testing.T, page *TopNIterator, expected ...string) {
+	t.Helper()
+	actual := keys(page)
+	sort.Strings(actual)
+	sort.Strings(expected)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected documents %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected documents %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestFieldScoping(t *testing.T) {
+	schema := []idxmodel.Field{
+		{Name: "title", Type: idxmodel.TypeText},
+		{Name: "body", Type: idxmodel.TypeText},
+		{Name: "price", Type: idxmodel.TypeNumeric},
+	}
+	e, s := newTestEngine(t, schema, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello world"), "body": []byte("goodbye")},
+		"doc:2": {"title": []byte("goodbye"), "body": []byte("hello world")},
+		"doc:3": {"title": []byte("world"), "body": []byte("hello"), "price": []byte("10")},
+	})
+
+	for query, expected := range map[string][]string{
+		"@title:hello":                {"doc:1"},
+		"@body:hello":                 {"doc:2", "doc:3"},
+		"@title|body:goodbye":         {"doc:1", "doc:2"},
+		"@title:(world | goodbye)":    {"doc:1", "doc:2", "doc:3"},
+		`@title:"hello world"`:        {"doc:1"},
+		`@body:"hello world"`:         {"doc:2"},
+		"@title:(hello world)":        {"doc:1"},
+		"@title:world @body:hello":    {"doc:3"},
+		"@title:world -@body:hello":   {"doc:1"},
+		"hello @title:(-hello)":       {"doc:2", "doc:3"},
+		"@title:goodbye | @body:hel*": {"doc:2", "doc:3"},
+	} {
+		page, err := search(t, e, s, query, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		assertKeySet(t, page, expected...)
+	}
+
+	for _, query := range []string{"@missing:hello", "@price:hello", "@title|missing:hello"} {
+		if _, err := search(t, e, s, query, Options{}); err == nil {
+			t.Errorf("%s: expected field to be rejected", query)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	e, s := newTestEngine(t, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello")},
+		"doc:2": {"title": []byte("help")},
+		"doc:3": {"title": []byte("helmet")},
+		"doc:4": {"title": []byte("running")},
+		"doc:5": {"title": []byte("world")},
+	})
+
+	for query, expected := range map[string][]string{
+		"hel*":  {"doc:1", "doc:2", "doc:3"},
+		"HEL*":  {"doc:1", "doc:2", "doc:3"},
+		"help*": {"doc:2"},
+		"xyz*":  {},
+		// stems of the indexed words and the words themselves are expanded
+		"run*":     {"doc:4"},
+		"runn*":    {"doc:4"},
+		"running":  {"doc:4"},
+		"hel* wo*": {},
+	} {
+		page, err := search(t, e, s, query, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		assertKeySet(t, page, expected...)
+	}
+
+	if _, err := search(t, e, s, "h*", Options{}); err == nil {
+		t.Error("expected prefix shorter than the minimum length to be rejected")
+	}
+
+	e.cfg.MinPrefix = 4
+	if _, err := search(t, e, s, "hel*", Options{}); err == nil {
+		t.Error("expected prefix shorter than the configured minimum length to be rejected")
+	}
+
+	e.cfg.MinPrefix = DefaultMinPrefix
+	e.cfg.MaxExpansions = 2
+	page, err := search(t, e, s, "hel*", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := keys(page); len(actual) != 2 {
+		t.Errorf("expected prefix to be expanded to 2 terms, got documents %v", actual)
+	}
+}
+
+func TestFuzzyScorePenalty(t *testing.T) {
+	e, s := newTestEngine(t, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello")},
+		"doc:2": {"title": []byte("hallo")},
+		"doc:3": {"title": []byte("hxllx")},
+		"doc:4": {"title": []byte("world")},
+	})
+
+	page, err := search(t, e, s, "%hello%", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, page, "doc:1", "doc:2")
+
+	page, err = search(t, e, s, "%%hello%%", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := make(map[string]float32)
+	for {
+		occ, score, ok := page.Next()
+		if !ok {
+			break
+		}
+		scores[occ.Doc.Key] = score
+	}
+	if len(scores) != 3 {
+		t.Fatalf("expected documents within distance 2, got %v", scores)
+	}
+	// the documents differ only by the matched term, so scores differ only by the penalty of its distance
+	for key, expected := range map[string]float32{"doc:2": 1 - index.FuzzyPenalty, "doc:3": 1 - 2*index.FuzzyPenalty} {
+		if ratio := scores[key] / scores["doc:1"]; math.Abs(float64(ratio-expected)) > 1e-4 {
+			t.Errorf("expected score of %s to be %v of the exact match, got %v", key, expected, ratio)
+		}
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	schema := []idxmodel.Field{
+		{Name: "title", Type: idxmodel.TypeText},
+		{Name: "price", Type: idxmodel.TypeNumeric, Sortable: true},
+	}
+	e, s := newTestEngine(t, schema, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello"), "price": []byte("10")},
+		"doc:2": {"title": []byte("world"), "price": []byte("20")},
+		"doc:3": {"title": []byte("hello world"), "price": []byte("30")},
+		"doc:4": {"title": []byte("goodbye")},
+	})
+
+	page, err := search(t, e, s, "*", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, page, "doc:1", "doc:2", "doc:3", "doc:4")
+
+	s.Begin(2)
+	s.Delete("doc:2")
+	s.Commit()
+
+	// deleted documents are not matched, all the others are ordered by the key as their scores are equal
+	page, err = search(t, e, s, "*", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, page, "doc:1", "doc:3", "doc:4")
+
+	for query, expected := range map[string][]string{
+		"@price:[10 20]":     {"doc:1"},
+		"@price:[-inf +inf]": {"doc:1", "doc:3"},
+		"-hello":             {"doc:4"},
+		"* hello":            {"doc:1", "doc:3"},
+	} {
+		page, err := search(t, e, s, query, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		assertKeySet(t, page, expected...)
+	}
+
+	page, err = search(t, e, s, "*", Options{SortBy: &SortBy{Field: "price", Desc: true}, Limit: &Limit{Offset: 0, Num: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total() != 3 {
+		t.Errorf("expected 3 matches, got %d", page.Total())
+	}
+	assertKeys(t, page, "doc:3", "doc:1")
+}
+
+func TestQueryAttributes(t *testing.T) {
+	e, s := newTestEngine(t, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello world")},
+		"doc:2": {"title": []byte("hello big world")},
+		"doc:3": {"title": []byte("world hello")},
+		"doc:4": {"title": []byte("goodbye")},
+		"doc:5": {"title": []byte("planet")},
+	})
+
+	for query, expected := range map[string][]string{
+		"(hello world)=>{$slop: 0}":                          {"doc:1", "doc:3"},
+		"(hello world)=>{$slop: 0; $inorder: true}":          {"doc:1"},
+		"(hello world)=>{$slop: 1}":                          {"doc:1", "doc:2", "doc:3"},
+		"(hello world)=>{$inorder: true;}":                   {"doc:1", "doc:2"},
+		"(hello world)=>{$phonetic: false}":                  {"doc:1", "doc:2", "doc:3"},
+		"((hello world)=>{$slop: 0})=>{$inorder: true}":      {"doc:1"},
+		"(hello world)=>{$slop: 0} | goodbye":                {"doc:1", "doc:3", "doc:4"},
+		"(goodbye)=>{$weight: 0.5} | (planet)=>{$weight: 2}": {"doc:4", "doc:5"},
+	} {
+		page, err := search(t, e, s, query, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		assertKeySet(t, page, expected...)
+	}
+
+	// the documents differ only by the matched term, so scores differ only by the weight
+	page, err := search(t, e, s, "(goodbye)=>{$weight: 3.0} | planet", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := make(map[string]float32)
+	for {
+		occ, score, ok := page.Next()
+		if !ok {
+			break
+		}
+		scores[occ.Doc.Key] = score
+	}
+	if ratio := scores["doc:4"] / scores["doc:5"]; math.Abs(float64(ratio-3)) > 1e-4 {
+		t.Errorf("expected weighted score to be 3 times the score, got %v", scores)
+	}
+
+	for _, query := range []string{
+		"(hello)=>{$phonetic: true}",
+		"(hello)=>{$unknown: 1}",
+		"(hello world)=>{$slop: x}",
+		"(hello world)=>{$inorder: maybe}",
+		"(hello)=>{$weight: abc}",
+	} {
+		if _, err := search(t, e, s, query, Options{}); err == nil {
+			t.Errorf("%s: expected attribute to be rejected", query)
+		}
+	}
+}
+
+func TestExplainScore(t *testing.T) {
+	e, s := newTestEngine(t, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello world")},
+		"doc:2": {"title": []byte("hello")},
+	})
+
+	page, err := search(t, e, s, "hello world", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if occ, _, _ := page.Next(); occ.Explanation != nil {
+		t.Errorf("expected no explanation unless requested, got %v", occ.Explanation)
+	}
+
+	page, err = search(t, e, s, "hello world", Options{ExplainScore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	occ, score, ok := page.Next()
+	if !ok || occ.Doc.Key != "doc:1" {
+		t.Fatalf("expected doc:1, got %v", keys(page))
+	}
+	explanation := occ.Explanation
+	if explanation == nil || !strings.HasPrefix(explanation.Description, "INTERSECT: ") || explanation.Score != score {
+		t.Fatalf("expected intersection explaining score %v, got %+v", score, explanation)
+	}
+	terms := make([]string, 0, len(explanation.Children))
+	for _, child := range explanation.Children {
+		terms = append(terms, strings.SplitN(child.Description, ":", 2)[0])
+	}
+	sort.Strings(terms)
+	if len(terms) != 2 || terms[0] != "TERM hello" || terms[1] != "TERM world" {
+		t.Errorf("expected explanations of the terms, got %v", terms)
+	}
+
+	page, err = search(t, e, s, "(hello)=>{$weight: 2}", Options{ExplainScore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		occ, score, ok := page.Next()
+		if !ok {
+			break
+		}
+		explanation := occ.Explanation
+		if explanation == nil || explanation.Description != "WEIGHT: score * 2" || explanation.Score != score ||
+			len(explanation.Children) != 1 || !strings.HasPrefix(explanation.Children[0].Description, "TERM hello: ") {
+			t.Errorf("%s: expected weighted term explanation, got %+v", occ.Doc.Key, explanation)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package search
+
+import (
+	"fmt"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+)
+
+// ExplainTermIterator explains scores of the term occurrences as the product of TF and IDF
+type ExplainTermIterator struct {
+	iter index.TermIterator
+	term string
+}
+
+func (e *ExplainTermIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	occurrence, score, ok = e.iter.Next()
+	if !ok {
+		return
+	}
+	idf := float32(0)
+	if occurrence.TF > 0 {
+		idf = score / occurrence.TF
+	}
+	occurrence.Explanation = &index.Explanation{
+		Score:       score,
+		Description: fmt.Sprintf("TERM %s: TF %s * IDF %s", e.term, formatScore(occurrence.TF), formatScore(idf)),
+	}
+	return occurrence, score, true
+}
+
+// ExplainTerm makes occurrences of the iterator over a single term explain their scores
+func ExplainTerm(iter index.TermIterator) index.TermIterator {
+	t, ok := iter.(interface{ Term() string })
+	if !ok {
+		return iter
+	}
+	return &ExplainTermIterator{iter: iter, term: t.Term()}
+}
+
+// explaining reports whether any of the combined occurrences explains its score,
+// so that composite iterators explain their scores only when the query does
+func explaining(values ...iterBufValue) bool {
+	for _, v := range values {
+		if v.occ.Explanation != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// explain describes the score combined from the values, values without explanation are explained by their score only
+func explain(score float32, description string, values ...iterBufValue) *index.Explanation {
+	e := &index.Explanation{Score: score, Description: description, Children: make([]*index.Explanation, len(values))}
+	for i, v := range values {
+		e.Children[i] = v.occ.Explanation
+		if e.Children[i] == nil {
+			e.Children[i] = &index.Explanation{Score: v.score, Description: "SCORE"}
+		}
+	}
+	return e
+}
+
+func formatScore(score float32) string {
+	return formatFloat(float64(score))
+}
@@ -0,0 +1,51 @@
+package search
+
+import (
+	"fmt"
+	"github.com/bits-and-blooms/bitset"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+)
+
+// FieldsIterator keeps only occurrences in the given fields, documents without such occurrences are skipped.
+// The score is reduced proportionally to the number of dropped occurrences.
+type FieldsIterator struct {
+	iter   index.TermIterator
+	fields *bitset.BitSet
+}
+
+func (f *FieldsIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	for {
+		occurrence, score, ok = f.iter.Next()
+		if !ok {
+			return
+		}
+		if f.fields.IsSuperSet(&occurrence.Fields) {
+			return
+		}
+		occurrences := make([]index.FieldTermOccurrence, 0, len(occurrence.Occurrences))
+		for _, o := range occurrence.Occurrences {
+			if f.fields.Test(uint(o.FieldIdx)) {
+				occurrences = append(occurrences, o)
+			}
+		}
+		if len(occurrences) == 0 {
+			continue
+		}
+		reduced := score * float32(len(occurrences)) / float32(len(occurrence.Occurrences))
+		if occurrence.Explanation != nil {
+			occurrence.Explanation = explain(reduced, fmt.Sprintf("FIELDS: score * %d of %d occurrences in the fields",
+				len(occurrences), len(occurrence.Occurrences)), iterBufValue{occ: occurrence, score: score})
+		}
+		score = reduced
+		occurrence.Fields = *occurrence.Fields.Intersection(f.fields)
+		occurrence.Occurrences = occurrences
+		return occurrence, score, true
+	}
+}
+
+func Fields(iter index.TermIterator, fields *bitset.BitSet) index.TermIterator {
+	if _, ok := iter.(index.StopWordIterator); ok {
+		return iter
+	}
+	return &FieldsIterator{iter: iter, fields: fields}
+}
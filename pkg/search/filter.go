@@ -0,0 +1,40 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+)
+
+// FilterIterator returns occurrences of the iterator for documents also returned by the filter,
+// unlike intersection the filter does not change the score
+type FilterIterator struct {
+	iter       index.TermIterator
+	filter     index.TermIterator
+	filterKey  string
+	filterDone bool
+}
+
+func (f *FilterIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	for !f.filterDone {
+		occurrence, score, ok = f.iter.Next()
+		if !ok {
+			return
+		}
+		for f.filterKey < occurrence.Doc.Key {
+			filterOcc, _, filterOk := f.filter.Next()
+			if !filterOk {
+				f.filterDone = true
+				break
+			}
+			f.filterKey = filterOcc.Doc.Key
+		}
+		if f.filterKey == occurrence.Doc.Key {
+			return occurrence, score, true
+		}
+	}
+	ok = false
+	return
+}
+
+func Filter(iter index.TermIterator, filter index.TermIterator) index.TermIterator {
+	return &FilterIterator{iter: iter, filter: filter}
+}
@@ -0,0 +1,86 @@
+package search
+
+import (
+	"github.com/bits-and-blooms/bitset"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sort"
+)
+
+const (
+	DefaultHighlightOpen  = "<b>"
+	DefaultHighlightClose = "</b>"
+)
+
+// Highlight wraps the tokens matched by the query in the text fields of the returned documents with the tags
+type Highlight struct {
+	// Fields are the highlighted text fields, nil for all of them
+	Fields []string
+	Open   string
+	Close  string
+}
+
+type highlighter struct {
+	Highlight
+	fields *bitset.BitSet
+	names  []string // text fields by FieldTermOccurrence.FieldIdx
+}
+
+func newHighlighter(idx *index.FTSIndex, h Highlight) (*highlighter, error) {
+	names := idx.TextFieldNames()
+	fields := h.Fields
+	if fields == nil {
+		fields = names
+	}
+	set, err := idx.TextFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	return &highlighter{Highlight: h, fields: set, names: names}, nil
+}
+
+// highlight returns a copy of the hash with the occurrences wrapped in the tags.
+// Occurrences are kept for every matched term, so tokens matched through stems, prefixes or fuzzy expansion
+// are highlighted as well.
+func (h *highlighter) highlight(occurrence index.DocTermOccurrence, hash storage.Hash) storage.Hash {
+	byField := make(map[int][]index.FieldTermOccurrence)
+	for _, o := range occurrence.Occurrences {
+		if h.fields.Test(uint(o.FieldIdx)) {
+			byField[o.FieldIdx] = append(byField[o.FieldIdx], o)
+		}
+	}
+	if len(byField) == 0 {
+		return hash
+	}
+
+	highlighted := hash.Clone()
+	for fieldIdx, occurrences := range byField {
+		name := h.names[fieldIdx]
+		if value, ok := hash[name]; ok {
+			highlighted[name] = h.wrap(value, occurrences)
+		}
+	}
+	return highlighted
+}
+
+// wrap surrounds the tokens of the value at the occurrences with the tags,
+// a token matched by several terms is wrapped once
+func (h *highlighter) wrap(value []byte, occurrences []index.FieldTermOccurrence) []byte {
+	sort.Slice(occurrences, func(a, b int) bool {
+		return occurrences[a].Offset < occurrences[b].Offset
+	})
+	wrapped := make([]byte, 0, len(value)+len(occurrences)*(len(h.Open)+len(h.Close)))
+	last := 0
+	for _, o := range occurrences {
+		end := o.Offset + o.Len
+		if o.Offset < last || end > len(value) {
+			continue
+		}
+		wrapped = append(wrapped, value[last:o.Offset]...)
+		wrapped = append(wrapped, h.Open...)
+		wrapped = append(wrapped, value[o.Offset:end]...)
+		wrapped = append(wrapped, h.Close...)
+		last = end
+	}
+	return append(wrapped, value[last:]...)
+}
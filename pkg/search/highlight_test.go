@@ -0,0 +1,92 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	schema := []idxmodel.Field{
+		{Name: "title", Type: idxmodel.TypeText},
+		{Name: "body", Type: idxmodel.TypeText},
+		{Name: "cat", Type: idxmodel.TypeTag, Separator: ","},
+	}
+	hash := storage.Hash{
+		"title": []byte("Hello, brave world!"),
+		"body":  []byte("hello world"),
+		"cat":   []byte("hello"),
+	}
+	idx, offset := newTestIndex(t, schema, map[string]storage.Hash{"doc:1": hash})
+	query := func() *TopNIterator {
+		return TopN(0, 10, Intersect(idx.Read("hello", offset), idx.Read("world", offset)), nil)
+	}
+
+	h, err := newHighlighter(idx, Highlight{Open: DefaultHighlightOpen, Close: DefaultHighlightClose})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := query()
+	page.highlight = h
+	occ, _, _ := page.Next()
+	highlighted := page.Highlight(occ, hash)
+	assertField(t, highlighted, "title", "<b>Hello</b>, brave <b>world</b>!")
+	assertField(t, highlighted, "body", "<b>hello</b> <b>world</b>")
+	assertField(t, highlighted, "cat", "hello")
+	assertField(t, hash, "title", "Hello, brave world!")
+
+	h, err = newHighlighter(idx, Highlight{Fields: []string{"body"}, Open: "[", Close: "]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = query()
+	page.highlight = h
+	occ, _, _ = page.Next()
+	highlighted = page.Highlight(occ, hash)
+	assertField(t, highlighted, "title", "Hello, brave world!")
+	assertField(t, highlighted, "body", "[hello] [world]")
+
+	if _, err = newHighlighter(idx, Highlight{Fields: []string{"cat"}}); err == nil {
+		t.Error("expected tag field to be rejected")
+	}
+}
+
+func assertField(t *testing.T, hash storage.Hash, field string, expected string) {
+	t.Helper()
+	if string(hash[field]) != expected {
+		t.Errorf("expected %s to be %q, got %q", field, expected, hash[field])
+	}
+}
+
+func TestHighlightExpandedTerms(t *testing.T) {
+	schema := []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}
+	hash := storage.Hash{"title": []byte("Running late, she runs to the helicopter: hello, help!")}
+	idx, offset := newTestIndex(t, schema, map[string]storage.Hash{"doc:1": hash})
+	h, err := newHighlighter(idx, Highlight{Open: DefaultHighlightOpen, Close: DefaultHighlightClose})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]struct {
+		iter     index.TermIterator
+		expected string
+	}{
+		"stem": {idx.Read("run", offset),
+			"<b>Running</b> late, she <b>runs</b> to the helicopter: hello, help!"},
+		"prefix": {UnionAll(idx.ReadPrefix("hel", DefaultMaxExpansions, offset)),
+			"Running late, she runs to the <b>helicopter</b>: <b>hello</b>, <b>help</b>!"},
+		"fuzzy": {UnionAll(idx.ReadFuzzy("helo", 1, DefaultMaxExpansions, offset)),
+			"Running late, she runs to the helicopter: <b>hello</b>, <b>help</b>!"},
+	} {
+		page := TopN(0, 10, c.iter, nil)
+		page.highlight = h
+		occ, _, ok := page.Next()
+		if !ok {
+			t.Fatalf("%s: expected doc:1 to match", name)
+		}
+		if highlighted := page.Highlight(occ, hash); string(highlighted["title"]) != c.expected {
+			t.Errorf("%s: expected %q, got %q", name, c.expected, highlighted["title"])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package search
+
+import (
+	"fmt"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FusionRRF    = "RRF"
+	FusionLinear = "LINEAR"
+
+	DefaultHybridK     = 10
+	DefaultRRFConstant = 60
+	DefaultAlpha       = 0.5
+)
+
+// Hybrid runs KNN query over the vector field together with the text query and fuses their rankings
+type Hybrid struct {
+	Field string
+	// Param is the name of the query parameter with the vector blob
+	Param string
+	K     int
+	// Filter is an optional query limiting documents of both text and vector rankings
+	Filter string
+	Fusion Fusion
+}
+
+type Fusion struct {
+	Method string
+	// Constant is added to the ranks by RRF
+	Constant float64
+	// Alpha is the weight of the text score in the linear combination, the vector score has weight 1 - Alpha
+	Alpha float64
+}
+
+func (f Fusion) String() string {
+	if f.Method == FusionLinear {
+		return fmt.Sprintf("%s ALPHA %s", f.Method, formatFloat(f.Alpha))
+	}
+	return fmt.Sprintf("%s CONSTANT %s", f.Method, formatFloat(f.Constant))
+}
+
+func (e Engine) hybridSearch(idx *index.FTSIndex, query string, opts Options, snapshot uint64) (index.TermIterator, error) {
+	h := opts.Hybrid
+	// iterators are read once, so the filter is parsed for each ranking
+	filter := func() (index.TermIterator, error) {
+		var iter index.TermIterator
+		if h.Filter != "" {
+			iter = e.parseQuery(idx, h.Filter, snapshot, Options{Params: opts.Params, Dialect: opts.Dialect})
+		}
+		for _, f := range opts.GeoFilters {
+			geoIter, err := idx.ReadGeo(f.Field, f.GeoRadius, snapshot)
+			if err != nil {
+				return nil, err
+			}
+			if iter == nil {
+				iter = geoIter
+			} else {
+				iter = Filter(iter, geoIter)
+			}
+		}
+		return iter, nil
+	}
+
+	text := e.parseQuery(idx, query, snapshot, opts)
+	textFilter, err := filter()
+	if err != nil {
+		return nil, err
+	}
+	if textFilter != nil {
+		text = Filter(text, textFilter)
+	}
+
+	vector, ok := opts.Params[strings.TrimPrefix(h.Param, "$")]
+	if !ok {
+		return nil, errors.Errorf("No such parameter `%s`", h.Param)
+	}
+	vectorFilter, err := filter()
+	if err != nil {
+		return nil, err
+	}
+	// the vector distance is returned as the score of the vector ranking
+	q := index.KNNQuery{K: h.K, Vector: []byte(vector), ScoreField: "__vector_score"}
+	knn, err := idx.ReadKNN(h.Field, q, vectorFilter, snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return Fuse(text, knn, h.Fusion), nil
+}
+
+type rankedValue struct {
+	iterBufValue
+	rank int
+}
+
+// rank reads all occurrences of the iterator and assigns them ranks starting from 1 by descending score
+func rank(iter index.TermIterator) (ranked []rankedValue, minScore float32, maxScore float32) {
+	ranked = make([]rankedValue, 0)
+	for {
+		occ, score, ok := iter.Next()
+		if !ok {
+			break
+		}
+		ranked = append(ranked, rankedValue{iterBufValue: iterBufValue{occ: occ, score: score}})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+	for i := range ranked {
+		ranked[i].rank = i + 1
+	}
+	if len(ranked) > 0 {
+		minScore, maxScore = ranked[len(ranked)-1].score, ranked[0].score
+	}
+	return ranked, minScore, maxScore
+}
+
+// Fuse combines text and vector rankings into one by reciprocal rank fusion or by linear combination
+// of min-max normalized scores. Ranks and scores of each ranking are returned as computed fields,
+// the vector ranking score is expected to be already computed.
+func Fuse(text index.TermIterator, vector index.TermIterator, f Fusion) index.TermIterator {
+	textRanked, textMin, textMax := rank(text)
+	vectorRanked, vectorMin, vectorMax := rank(vector)
+
+	normalize := func(score float32, min float32, max float32) float64 {
+		if max == min {
+			return 1
+		}
+		return float64(score-min) / float64(max-min)
+	}
+
+	type fused struct {
+		occ   index.DocTermOccurrence
+		score float64
+		legs  []iterBufValue
+	}
+	docs := make(map[*storage.Document]*fused)
+	add := func(v rankedValue, weight float64, normalized float64, leg string) {
+		var contribution float64
+		if f.Method == FusionLinear {
+			contribution = weight * normalized
+		} else {
+			contribution = 1 / (f.Constant + float64(v.rank))
+		}
+		d, found := docs[v.occ.Doc]
+		if !found {
+			d = &fused{occ: v.occ}
+			d.occ.Computed = make(storage.Hash, len(v.occ.Computed)+5)
+			for k, val := range v.occ.Computed {
+				d.occ.Computed[k] = val
+			}
+			docs[v.occ.Doc] = d
+		} else {
+			d.occ = mergeOccurrences(d.occ, v.occ)
+		}
+		d.score += contribution
+		d.legs = append(d.legs, v.iterBufValue)
+		d.occ.Computed[fmt.Sprintf("__%s_rank", leg)] = []byte(strconv.Itoa(v.rank))
+	}
+	for _, v := range textRanked {
+		add(v, f.Alpha, normalize(v.score, textMin, textMax), "text")
+		docs[v.occ.Doc].occ.Computed["__text_score"] = []byte(formatFloat(float64(v.score)))
+	}
+	for _, v := range vectorRanked {
+		add(v, 1-f.Alpha, normalize(v.score, vectorMin, vectorMax), "vector")
+	}
+
+	values := make([]iterBufValue, 0, len(docs))
+	for _, d := range docs {
+		d.occ.Computed["__hybrid_score"] = []byte(formatFloat(d.score))
+		d.occ.Computed["__fusion"] = []byte(f.String())
+		if explaining(d.legs...) {
+			d.occ.Explanation = explain(float32(d.score), "FUSION "+f.String(), d.legs...)
+		}
+		values = append(values, iterBufValue{occ: d.occ, score: float32(d.score)})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].occ.Doc.Key < values[j].occ.Doc.Key
+	})
+	return &TopNIterator{values: values}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 32)
+}
@@ -0,0 +1,111 @@
+package search
+
+import (
+	"encoding/binary"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"math"
+	"testing"
+)
+
+var hybridSchema = []idxmodel.Field{
+	{Name: "title", Type: idxmodel.TypeText},
+	{Name: "cat", Type: idxmodel.TypeTag, Separator: ","},
+	{Name: "vec", Type: idxmodel.TypeVector, Vector: &idxmodel.VectorOptions{
+		Algorithm: idxmodel.AlgorithmFlat, Type: idxmodel.VectorFloat32, Dim: 2, Metric: idxmodel.MetricL2}},
+}
+
+func vectorBlob(values ...float32) []byte {
+	blob := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(blob[i*4:], math.Float32bits(v))
+	}
+	return blob
+}
+
+var hybridDocs = map[string]storage.Hash{
+	"doc:1": {"title": []byte("hello world"), "cat": []byte("news"), "vec": vectorBlob(0, 0)},
+	"doc:2": {"title": []byte("hello there"), "cat": []byte("sport"), "vec": vectorBlob(0.1, 0)},
+	"doc:3": {"title": []byte("goodbye"), "cat": []byte("news"), "vec": vectorBlob(5, 5)},
+}
+
+func TestHybridFilterParamsWithDialect(t *testing.T) {
+	e, s := newTestEngine(t, hybridSchema, hybridDocs)
+	opts := func(dialect int) Options {
+		return Options{
+			Dialect: dialect,
+			Params:  map[string]string{"vec": string(vectorBlob(0, 0)), "cat": "news"},
+			Hybrid: &Hybrid{Field: "vec", Param: "$vec", K: 10, Filter: "@cat:{$cat}",
+				Fusion: Fusion{Method: FusionRRF, Constant: DefaultRRFConstant}},
+		}
+	}
+
+	page, err := search(t, e, s, "hello", opts(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, page, "doc:1", "doc:3")
+
+	// the tag is read literally in DIALECT 1, so the filter matches nothing
+	page, err = search(t, e, s, "hello", opts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, page)
+}
+
+func TestFuse(t *testing.T) {
+	docs := map[string]*storage.Document{}
+	ranking := func(scores ...interface{}) index.TermIterator {
+		values := make([]iterBufValue, 0)
+		for i := 0; i < len(scores); i += 2 {
+			key := scores[i].(string)
+			if docs[key] == nil {
+				docs[key] = &storage.Document{Key: key}
+			}
+			values = append(values, iterBufValue{occ: index.DocTermOccurrence{Doc: docs[key]}, score: scores[i+1].(float32)})
+		}
+		return &TopNIterator{values: values}
+	}
+	fuse := func(f Fusion) map[string]iterBufValue {
+		result := map[string]iterBufValue{}
+		iter := Fuse(ranking("doc:2", float32(1), "doc:1", float32(3)), ranking("doc:3", float32(0.5), "doc:2", float32(0.9)), f)
+		for {
+			occ, score, ok := iter.Next()
+			if !ok {
+				return result
+			}
+			result[occ.Doc.Key] = iterBufValue{occ: occ, score: score}
+		}
+	}
+	assertScores := func(fused map[string]iterBufValue, expected map[string]float64) {
+		t.Helper()
+		if len(fused) != len(expected) {
+			t.Fatalf("expected documents %v, got %v", expected, fused)
+		}
+		for key, score := range expected {
+			if math.Abs(float64(fused[key].score)-score) > 1e-6 {
+				t.Errorf("expected %s score %v, got %v", key, score, fused[key].score)
+			}
+		}
+	}
+
+	rrf := fuse(Fusion{Method: FusionRRF, Constant: 60})
+	assertScores(rrf, map[string]float64{"doc:1": 1.0 / 61, "doc:2": 1.0/62 + 1.0/61, "doc:3": 1.0 / 62})
+	if rank := string(rrf["doc:2"].occ.Computed["__text_rank"]); rank != "2" {
+		t.Errorf("expected doc:2 text rank 2, got %s", rank)
+	}
+	if rank := string(rrf["doc:2"].occ.Computed["__vector_rank"]); rank != "1" {
+		t.Errorf("expected doc:2 vector rank 1, got %s", rank)
+	}
+	if _, found := rrf["doc:3"].occ.Computed["__text_rank"]; found {
+		t.Error("expected doc:3 to have no text rank")
+	}
+
+	linear := fuse(Fusion{Method: FusionLinear, Alpha: 0.7})
+	assertScores(linear, map[string]float64{"doc:1": 0.7, "doc:2": 0.3, "doc:3": 0})
+	if fusion := string(linear["doc:1"].occ.Computed["__fusion"]); fusion != "LINEAR ALPHA 0.7" {
+		t.Errorf("expected fusion LINEAR ALPHA 0.7, got %s", fusion)
+	}
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"fmt"
 	"github.com/kuzznya/go-redis-search-replica/pkg/index"
 	"math"
 )
@@ -48,11 +49,13 @@ func (ii *IntersectIterator) Next() (occurrence index.DocTermOccurrence, score f
 		}
 
 		if buf1.occ.Doc.Key == buf2.occ.Doc.Key {
-			fields := buf1.occ.Fields
-			fields.InPlaceUnion(&buf2.occ.Fields)
-			occurrences := append(buf1.occ.Occurrences, buf2.occ.Occurrences...)
-			result := index.DocTermOccurrence{Doc: buf1.occ.Doc, TF: 0, Fields: fields, Occurrences: occurrences}
-			score = (buf1.score + buf2.score) / distancePenalty(buf1.occ.Occurrences, buf2.occ.Occurrences)
+			result := mergeOccurrences(buf1.occ, buf2.occ)
+			penalty := distancePenalty(buf1.occ.Occurrences, buf2.occ.Occurrences)
+			score = (buf1.score + buf2.score) / penalty
+			if explaining(buf1, buf2) {
+				result.Explanation = explain(score,
+					fmt.Sprintf("INTERSECT: sum of scores / distance penalty %s", formatScore(penalty)), buf1, buf2)
+			}
 			return result, score, true
 		}
 		// skip buffer for the iterator with greater key as the other iterator can return the same key later
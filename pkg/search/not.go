@@ -0,0 +1,41 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+)
+
+// NotIterator returns occurrences of the universe for documents not returned by the excluded iterator
+type NotIterator struct {
+	iter         index.TermIterator
+	universe     index.TermIterator
+	excludedKey  string
+	excludedDone bool
+}
+
+func (n *NotIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	for {
+		occurrence, score, ok = n.universe.Next()
+		if !ok {
+			return
+		}
+		for !n.excludedDone && n.excludedKey < occurrence.Doc.Key {
+			excludedOcc, _, excludedOk := n.iter.Next()
+			if !excludedOk {
+				n.excludedDone = true
+				break
+			}
+			n.excludedKey = excludedOcc.Doc.Key
+		}
+		if n.excludedDone || n.excludedKey != occurrence.Doc.Key {
+			return occurrence, score, true
+		}
+	}
+}
+
+// Not excludes documents of the iterator from the universe, e.g. from all documents of the index
+func Not(iter index.TermIterator, universe index.TermIterator) index.TermIterator {
+	if _, ok := iter.(index.StopWordIterator); ok {
+		return universe
+	}
+	return &NotIterator{iter: iter, universe: universe}
+}
@@ -0,0 +1,48 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+)
+
+// OptionalIterator returns all occurrences of the base iterator, documents also returned
+// by the optional iterator get their occurrences and score added
+type OptionalIterator struct {
+	base        index.TermIterator
+	optional    index.TermIterator
+	buf         iterBufValue
+	bufHasValue bool
+	done        bool
+}
+
+func (o *OptionalIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	occurrence, score, ok = o.base.Next()
+	if !ok {
+		return
+	}
+	for !o.done && (!o.bufHasValue || o.buf.occ.Doc.Key < occurrence.Doc.Key) {
+		occ, s, optionalOk := o.optional.Next()
+		if !optionalOk {
+			o.done = true
+			break
+		}
+		o.buf = iterBufValue{occ: occ, score: s}
+		o.bufHasValue = true
+	}
+	if o.bufHasValue && o.buf.occ.Doc.Key == occurrence.Doc.Key {
+		base := iterBufValue{occ: occurrence, score: score}
+		occurrence = mergeOccurrences(occurrence, o.buf.occ)
+		if explaining(base, o.buf) {
+			occurrence.Explanation = explain(score+o.buf.score, "OPTIONAL: sum of scores", base, o.buf)
+		}
+		return occurrence, score + o.buf.score, true
+	}
+	return occurrence, score, true
+}
+
+// Optional boosts documents of the base iterator which are also returned by the optional one
+func Optional(base index.TermIterator, optional index.TermIterator) index.TermIterator {
+	if _, ok := optional.(index.StopWordIterator); ok {
+		return base
+	}
+	return &OptionalIterator{base: base, optional: optional}
+}
@@ -0,0 +1,144 @@
+package search
+
+import (
+	"github.com/bits-and-blooms/bitset"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+)
+
+// PhraseIterator returns documents where the terms occur in the same field at the given position offsets
+// from the first term. Offsets of the terms following stop words are increased, as stop words are not indexed,
+// but occupy positions.
+type PhraseIterator struct {
+	iters   []index.TermIterator
+	offsets []int
+	drained bool
+}
+
+func (p *PhraseIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	if p.drained {
+		ok = false
+		return
+	}
+
+	bufs := make([]iterBufValue, len(p.iters))
+	for {
+		if !align(p.iters, bufs) {
+			p.drained = true
+			return index.DocTermOccurrence{}, 0, false
+		}
+
+		if matches := p.match(bufs); len(matches) > 0 {
+			fields := bitset.New(0)
+			for _, m := range matches {
+				fields.Set(uint(m.FieldIdx))
+			}
+			score = 0
+			for _, b := range bufs {
+				score += b.score
+			}
+			occurrence = index.DocTermOccurrence{Doc: bufs[0].occ.Doc, Fields: *fields, Occurrences: matches}
+			if explaining(bufs...) {
+				occurrence.Explanation = explain(score, "PHRASE: sum of scores", bufs...)
+			}
+			return occurrence, score, true
+		}
+		// the document does not contain the phrase, move on from it
+		for i := range bufs {
+			bufs[i].occ.Doc = nil
+		}
+	}
+}
+
+// align advances the iterators until all of them return the same document, buffers without a document are refilled.
+// It returns false if any iterator is drained.
+func align(iters []index.TermIterator, bufs []iterBufValue) bool {
+	next := func(i int) bool {
+		occ, s, ok := iters[i].Next()
+		if !ok {
+			return false
+		}
+		bufs[i] = iterBufValue{occ: occ, score: s}
+		return true
+	}
+
+	for {
+		maxKey := ""
+		for i := range bufs {
+			if bufs[i].occ.Doc == nil && !next(i) {
+				return false
+			}
+			if bufs[i].occ.Doc.Key > maxKey {
+				maxKey = bufs[i].occ.Doc.Key
+			}
+		}
+
+		// advance every iterator to the greatest key, all of them should point to the same document
+		sameDoc := true
+		for i := range bufs {
+			for bufs[i].occ.Doc.Key < maxKey {
+				if !next(i) {
+					return false
+				}
+			}
+			sameDoc = sameDoc && bufs[i].occ.Doc.Key == maxKey
+		}
+		if sameDoc {
+			return true
+		}
+	}
+}
+
+// match returns occurrences of the terms forming the phrase in the document
+func (p *PhraseIterator) match(bufs []iterBufValue) []index.FieldTermOccurrence {
+	type position struct {
+		field int
+		pos   int
+	}
+	positions := make([]map[position]index.FieldTermOccurrence, len(bufs))
+	for i := 1; i < len(bufs); i++ {
+		positions[i] = make(map[position]index.FieldTermOccurrence, len(bufs[i].occ.Occurrences))
+		for _, o := range bufs[i].occ.Occurrences {
+			positions[i][position{o.FieldIdx, o.Pos}] = o
+		}
+	}
+
+	matches := make([]index.FieldTermOccurrence, 0)
+	for _, first := range bufs[0].occ.Occurrences {
+		phrase := []index.FieldTermOccurrence{first}
+		for i := 1; i < len(bufs); i++ {
+			o, found := positions[i][position{first.FieldIdx, first.Pos + p.offsets[i]}]
+			if !found {
+				phrase = nil
+				break
+			}
+			phrase = append(phrase, o)
+		}
+		matches = append(matches, phrase...)
+	}
+	return matches
+}
+
+// Phrase combines iterators of consecutive phrase words, stop words are skipped.
+// Offsets are positions of the words in the phrase.
+func Phrase(iters []index.TermIterator, offsets []int) index.TermIterator {
+	words := make([]index.TermIterator, 0, len(iters))
+	wordOffsets := make([]int, 0, len(offsets))
+	for i, iter := range iters {
+		if _, ok := iter.(index.StopWordIterator); ok {
+			continue
+		}
+		words = append(words, iter)
+		wordOffsets = append(wordOffsets, offsets[i])
+	}
+	switch len(words) {
+	case 0:
+		return index.StopWordIterator{}
+	case 1:
+		return words[0]
+	}
+	first := wordOffsets[0]
+	for i := range wordOffsets {
+		wordOffsets[i] -= first
+	}
+	return &PhraseIterator{iters: words, offsets: wordOffsets}
+}
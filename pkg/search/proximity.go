@@ -0,0 +1,165 @@
+package search
+
+import (
+	"fmt"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"math"
+	"sort"
+)
+
+// ProximityIterator returns documents where occurrences of all iterators are within slop intervening tokens,
+// and also in the order of the iterators if required. Positions are counted across fields, so the occurrences
+// must be in the same field, documents with the terms only in different fields are skipped.
+// Iterators returning documents without term positions, e.g. numeric, tag or geo filters, are only intersected.
+// The score is the sum of the scores reduced by the number of intervening tokens.
+type ProximityIterator struct {
+	iters   []index.TermIterator
+	slop    int // -1 for any number of intervening tokens
+	inOrder bool
+	drained bool
+}
+
+func (p *ProximityIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	if p.drained {
+		ok = false
+		return
+	}
+
+	bufs := make([]iterBufValue, len(p.iters))
+	for {
+		if !align(p.iters, bufs) {
+			p.drained = true
+			return index.DocTermOccurrence{}, 0, false
+		}
+
+		if slack, found := p.match(bufs); found {
+			occurrence = bufs[0].occ
+			score = bufs[0].score
+			for _, b := range bufs[1:] {
+				occurrence = mergeOccurrences(occurrence, b.occ)
+				score += b.score
+			}
+			score /= float32(1 + slack)
+			if explaining(bufs...) {
+				occurrence.Explanation = explain(score,
+					fmt.Sprintf("PROXIMITY: sum of scores / (1 + %d intervening tokens)", slack), bufs...)
+			}
+			return occurrence, score, true
+		}
+		// the terms are too far from each other in the document, move on from it
+		for i := range bufs {
+			bufs[i].occ.Doc = nil
+		}
+	}
+}
+
+// match returns the least number of intervening tokens among the fields containing occurrences of all iterators
+// with term positions
+func (p *ProximityIterator) match(bufs []iterBufValue) (slack int, found bool) {
+	positions := make([]map[int][]int, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b.occ.Occurrences) == 0 {
+			continue
+		}
+		fields := make(map[int][]int)
+		for _, o := range b.occ.Occurrences {
+			fields[o.FieldIdx] = append(fields[o.FieldIdx], o.Pos)
+		}
+		positions = append(positions, fields)
+	}
+	if len(positions) < 2 {
+		return 0, true
+	}
+
+	slack = math.MaxInt
+	for field := range positions[0] {
+		lists := make([][]int, len(positions))
+		for i := range positions {
+			if lists[i] = positions[i][field]; len(lists[i]) == 0 {
+				break
+			}
+			sort.Ints(lists[i])
+		}
+		if len(lists[len(lists)-1]) == 0 {
+			continue
+		}
+		var s int
+		if p.inOrder {
+			s = orderedSlack(lists)
+		} else {
+			s = unorderedSlack(lists)
+		}
+		if s < slack {
+			slack = s
+		}
+	}
+	if slack == math.MaxInt || p.slop >= 0 && slack > p.slop {
+		return 0, false
+	}
+	if slack < 0 {
+		slack = 0
+	}
+	return slack, true
+}
+
+// unorderedSlack returns the least number of tokens between the positions of the smallest window
+// containing a position of each list, the lists are sorted
+func unorderedSlack(lists [][]int) int {
+	slack := math.MaxInt
+	idx := make([]int, len(lists))
+	for {
+		minList, minPos, maxPos := 0, math.MaxInt, math.MinInt
+		for i, l := range lists {
+			if l[idx[i]] < minPos {
+				minList, minPos = i, l[idx[i]]
+			}
+			if l[idx[i]] > maxPos {
+				maxPos = l[idx[i]]
+			}
+		}
+		if s := maxPos - minPos - (len(lists) - 1); s < slack {
+			slack = s
+		}
+		if idx[minList]++; idx[minList] == len(lists[minList]) {
+			return slack
+		}
+	}
+}
+
+// orderedSlack returns the least number of tokens between increasing positions taken from the lists in order,
+// math.MaxInt if there are no such positions. The lists are sorted.
+func orderedSlack(lists [][]int) int {
+	slack := math.MaxInt
+	for _, first := range lists[0] {
+		prev := first
+		for _, l := range lists[1:] {
+			idx := sort.SearchInts(l, prev+1)
+			if idx == len(l) {
+				// later first positions cannot be followed by the list either
+				return slack
+			}
+			prev = l[idx]
+		}
+		if s := prev - first - (len(lists) - 1); s < slack {
+			slack = s
+		}
+	}
+	return slack
+}
+
+// Proximity intersects the iterators limiting the distance between their occurrences, stop words are skipped
+func Proximity(iters []index.TermIterator, slop int, inOrder bool) index.TermIterator {
+	words := make([]index.TermIterator, 0, len(iters))
+	for _, iter := range iters {
+		if _, ok := iter.(index.StopWordIterator); !ok {
+			words = append(words, iter)
+		}
+	}
+	switch len(words) {
+	case 0:
+		return index.StopWordIterator{}
+	case 1:
+		return words[0]
+	}
+	return &ProximityIterator{iters: words, slop: slop, inOrder: inOrder}
+}
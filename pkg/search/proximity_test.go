@@ -0,0 +1,68 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"testing"
+)
+
+var proximitySchema = []idxmodel.Field{
+	{Name: "title", Type: idxmodel.TypeText},
+	{Name: "body", Type: idxmodel.TypeText},
+	{Name: "cat", Type: idxmodel.TypeTag, Separator: ","},
+	{Name: "year", Type: idxmodel.TypeNumeric},
+}
+
+var proximityDocs = map[string]storage.Hash{
+	"doc:1": {"title": []byte("hello big world"), "cat": []byte("news"), "year": []byte("2020")},
+	"doc:2": {"title": []byte("world says hello"), "cat": []byte("news"), "year": []byte("2010")},
+	"doc:3": {"title": []byte("hello there my dear old world"), "cat": []byte("news"), "year": []byte("2020")},
+	"doc:4": {"title": []byte("hello"), "body": []byte("world"), "cat": []byte("news"), "year": []byte("2020")},
+	"doc:5": {"title": []byte("hello world"), "cat": []byte("sport"), "year": []byte("2020")},
+}
+
+func TestProximitySlop(t *testing.T) {
+	idx, snapshot := newTestIndex(t, proximitySchema, proximityDocs)
+	read := func() []index.TermIterator {
+		return []index.TermIterator{idx.Read("hello", snapshot), idx.Read("world", snapshot)}
+	}
+
+	assertKeys(t, Proximity(read(), 0, false), "doc:5")
+	assertKeys(t, Proximity(read(), 1, false), "doc:1", "doc:2", "doc:5")
+	assertKeys(t, Proximity(read(), 1, true), "doc:1", "doc:5")
+	// terms in different fields never match
+	assertKeys(t, Proximity(read(), -1, false), "doc:1", "doc:2", "doc:3", "doc:5")
+	assertKeys(t, Proximity(read(), -1, true), "doc:1", "doc:3", "doc:5")
+}
+
+func TestProximityWithFilters(t *testing.T) {
+	idx, snapshot := newTestIndex(t, proximitySchema, proximityDocs)
+	tags := func() index.TermIterator {
+		iter, err := idx.ReadTags("cat", []string{"news"}, snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iter
+	}
+	years := func() index.TermIterator {
+		iter, err := idx.ReadNumeric("year", index.NumericRange{Min: 2015, Max: 2025}, snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iter
+	}
+
+	// hello @cat:{news} with SLOP 2, the tag has no positions and is only intersected
+	assertKeys(t, Proximity([]index.TermIterator{idx.Read("hello", snapshot), tags()}, 2, false),
+		"doc:1", "doc:2", "doc:3", "doc:4")
+	// hello world @cat:{news} @year:[2015 2025] with SLOP 1 INORDER
+	iters := []index.TermIterator{idx.Read("hello", snapshot), idx.Read("world", snapshot), tags(), years()}
+	assertKeys(t, Proximity(iters, 1, true), "doc:1")
+	// filters placed between the terms do not break the order
+	iters = []index.TermIterator{idx.Read("hello", snapshot), years(), idx.Read("world", snapshot)}
+	assertKeys(t, Proximity(iters, 1, true), "doc:1", "doc:5")
+	// match-all leg
+	assertKeys(t, Proximity([]index.TermIterator{idx.ReadAll(snapshot), idx.Read("world", snapshot)}, 0, true),
+		"doc:1", "doc:2", "doc:3", "doc:4", "doc:5")
+}
@@ -1,8 +1,10 @@
 package search
 
 import (
+	"github.com/emirpasic/gods/trees/binaryheap"
 	"github.com/kuzznya/go-redis-search-replica/pkg/index"
-	"sort"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"math"
 )
 
 type iterBufValue struct {
@@ -10,8 +12,31 @@ type iterBufValue struct {
 	score float32
 }
 
+// mergeOccurrences combines occurrences of two terms in the same document.
+// Posting lists are shared between concurrent queries, so the result never reuses their bitsets or slices.
+func mergeOccurrences(occ1 index.DocTermOccurrence, occ2 index.DocTermOccurrence) index.DocTermOccurrence {
+	fields := occ1.Fields.Union(&occ2.Fields)
+	occurrences := make([]index.FieldTermOccurrence, 0, len(occ1.Occurrences)+len(occ2.Occurrences))
+	occurrences = append(occurrences, occ1.Occurrences...)
+	occurrences = append(occurrences, occ2.Occurrences...)
+	computed := occ1.Computed
+	if len(occ2.Computed) > 0 {
+		computed = make(storage.Hash, len(occ1.Computed)+len(occ2.Computed))
+		for k, v := range occ1.Computed {
+			computed[k] = v
+		}
+		for k, v := range occ2.Computed {
+			computed[k] = v
+		}
+	}
+	return index.DocTermOccurrence{Doc: occ1.Doc, TF: 0, Fields: *fields, Occurrences: occurrences, Computed: computed}
+}
+
+// TopNIterator returns the page of the best scored documents
 type TopNIterator struct {
-	values []iterBufValue
+	values    []iterBufValue
+	total     int
+	highlight *highlighter
 }
 
 func (t *TopNIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
@@ -26,32 +51,72 @@ func (t *TopNIterator) Next() (occurrence index.DocTermOccurrence, score float32
 	return
 }
 
-func TopN(offset int, limit int, iter index.TermIterator) index.TermIterator {
+// Total returns the number of matched documents including the ones outside of the page
+func (t *TopNIterator) Total() int {
+	return t.total
+}
+
+// Highlight returns the hash of the returned document with the matched tokens wrapped in the tags
+// if highlighting was requested, otherwise the hash is returned as is
+func (t *TopNIterator) Highlight(occurrence index.DocTermOccurrence, hash storage.Hash) storage.Hash {
+	if t.highlight == nil {
+		return hash
+	}
+	return t.highlight.highlight(occurrence, hash)
+}
+
+// TopN drains the iterator to count all matched documents and keeps the page of the best ranked ones.
+// Documents are ranked by descending score, or by the sort keys if sortBy is set. Ties are broken by the key,
+// so the order of equal documents is the same as the order in which iterators return them.
+// Only the best offset + limit documents are kept in a bounded heap, with LIMIT 0 0 nothing is kept.
+func TopN(offset int, limit int, iter index.TermIterator, sortBy *SortBy) *TopNIterator {
 	if _, ok := iter.(index.StopWordIterator); ok {
-		return index.Empty()
+		return &TopNIterator{}
 	}
 
-	values := make([]iterBufValue, 0)
+	size := offset + limit
+	if size < 0 {
+		// the page is unbounded
+		size = math.MaxInt
+	}
+	// the root of the heap is the worst of the kept documents, it is replaced by better ones
+	heap := binaryheap.NewWith(func(a, b interface{}) int {
+		switch v1, v2 := a.(iterBufValue), b.(iterBufValue); {
+		case sortBy.better(v1, v2):
+			return 1
+		case sortBy.better(v2, v1):
+			return -1
+		}
+		return 0
+	})
+	total := 0
 	for {
 		occ, score, ok := iter.Next()
 		if !ok {
 			break
 		}
-		values = append(values, iterBufValue{occ: occ, score: score})
+		total++
+		if limit == 0 {
+			continue
+		}
+		v := iterBufValue{occ: occ, score: score}
+		if heap.Size() < size {
+			heap.Push(v)
+			continue
+		}
+		if worst, _ := heap.Peek(); sortBy.better(v, worst.(iterBufValue)) {
+			heap.Pop()
+			heap.Push(v)
+		}
 	}
 
-	if len(values) <= offset {
-		return index.Empty()
+	if heap.Size() <= offset {
+		return &TopNIterator{total: total}
 	}
-
-	sort.Slice(values, func(i, j int) bool {
-		return values[i].score >= values[j].score
-	})
-
-	if len(values) < offset+limit {
-		limit = len(values) - offset
+	values := make([]iterBufValue, heap.Size())
+	for idx := len(values) - 1; idx >= 0; idx-- {
+		v, _ := heap.Pop()
+		values[idx] = v.(iterBufValue)
 	}
-	values = values[offset : offset+limit]
-
-	return &TopNIterator{values: values}
+	return &TopNIterator{values: values[offset:], total: total}
 }
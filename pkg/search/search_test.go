@@ -0,0 +1,134 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/memory"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"sort"
+	"testing"
+)
+
+const testIndex = "idx"
+
+// newTestEngine creates engine with the index over all keys and saves the documents, they are indexed synchronously
+func newTestEngine(t *testing.T, schema []idxmodel.Field, docs map[string]storage.Hash) (Engine, storage.Storage) {
+	t.Helper()
+	s := storage.NewMemory()
+	e := NewEngine(s, memory.NewBudget(0, memory.RejectCreate), DefaultConfig())
+	idx := index.NewFTSIndex(s, []string{""}, schema)
+	idx.Load(nil)
+	e.indexes[testIndex] = idx
+
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s.Begin(1)
+	for _, key := range keys {
+		s.Save(key, docs[key])
+	}
+	s.Commit()
+	return e, s
+}
+
+// search runs the query against the latest snapshot
+func search(t *testing.T, e Engine, s storage.Storage, query string, opts Options) (*TopNIterator, error) {
+	t.Helper()
+	snapshot := s.Snapshot()
+	defer snapshot.Release()
+	return e.Search(testIndex, query, opts, snapshot)
+}
+
+// newTestIndex saves the documents to a new storage and indexes them, returns the snapshot offset seeing all of them
+func newTestIndex(t *testing.T, schema []idxmodel.Field, docs map[string]storage.Hash) (*index.FTSIndex, uint64) {
+	t.Helper()
+	s := storage.NewMemory()
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s.Begin(1)
+	for _, key := range keys {
+		s.Save(key, docs[key])
+	}
+	s.Commit()
+
+	idx := index.NewFTSIndex(s, []string{""}, schema)
+	idx.Load(s.GetAll([]string{""}))
+	return idx, 1
+}
+
+// keys reads the iterator and returns keys of the documents in the order they are returned
+func keys(iter index.TermIterator) []string {
+	result := make([]string, 0)
+	for {
+		occ, _, ok := iter.Next()
+		if !ok {
+			return result
+		}
+		result = append(result, occ.Doc.Key)
+	}
+}
+
+func assertKeys(t *testing.T, iter index.TermIterator, expected ...string) {
+	t.Helper()
+	actual := keys(iter)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected documents %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected documents %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestTopNCountsAllMatches(t *testing.T) {
+	schema := []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}
+	idx, offset := newTestIndex(t, schema, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello world planet")},
+		"doc:2": {"title": []byte("hello hello hello")},
+		"doc:3": {"title": []byte("hello hello world")},
+		"doc:4": {"title": []byte("hello")},
+		"doc:5": {"title": []byte("world")},
+	})
+
+	ranked := make([]iterBufValue, 0)
+	iter := idx.Read("hello", offset)
+	for {
+		occ, score, ok := iter.Next()
+		if !ok {
+			break
+		}
+		ranked = append(ranked, iterBufValue{occ: occ, score: score})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return (*SortBy)(nil).better(ranked[i], ranked[j])
+	})
+	expected := make([]string, 0)
+	for _, v := range ranked[1:3] {
+		expected = append(expected, v.occ.Doc.Key)
+	}
+
+	page := TopN(1, 2, idx.Read("hello", offset), nil)
+	if page.Total() != 4 {
+		t.Errorf("expected 4 matches, got %d", page.Total())
+	}
+	assertKeys(t, page, expected...)
+
+	// LIMIT 0 0 only counts
+	page = TopN(0, 0, idx.Read("hello", offset), nil)
+	if page.Total() != 4 {
+		t.Errorf("expected 4 matches, got %d", page.Total())
+	}
+	assertKeys(t, page)
+
+	page = TopN(10, 10, idx.Read("hello", offset), nil)
+	if page.Total() != 4 {
+		t.Errorf("expected 4 matches, got %d", page.Total())
+	}
+	assertKeys(t, page)
+}
@@ -0,0 +1,32 @@
+package search
+
+// SortBy orders results by the sort key of the sortable field instead of the score
+type SortBy struct {
+	Field string
+	Desc  bool
+}
+
+// better reports whether the first document is ranked before the second one. Documents are ranked
+// by descending score if sortBy is nil, documents without the sort key are ranked last in any order.
+func (s *SortBy) better(v1 iterBufValue, v2 iterBufValue) bool {
+	if s == nil {
+		if v1.score != v2.score {
+			return v1.score > v2.score
+		}
+		return v1.occ.Doc.Key < v2.occ.Doc.Key
+	}
+
+	k1, k2 := v1.occ.SortKey, v2.occ.SortKey
+	switch {
+	case k1 == nil && k2 == nil:
+	case k1 == nil:
+		return false
+	case k2 == nil:
+		return true
+	case k1.Less(*k2):
+		return !s.Desc
+	case k2.Less(*k1):
+		return s.Desc
+	}
+	return v1.occ.Doc.Key < v2.occ.Doc.Key
+}
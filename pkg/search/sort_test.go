@@ -0,0 +1,51 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"testing"
+)
+
+func TestSortBy(t *testing.T) {
+	schema := []idxmodel.Field{
+		{Name: "name", Type: idxmodel.TypeText, Sortable: true},
+		{Name: "price", Type: idxmodel.TypeNumeric, Sortable: true},
+		{Name: "title", Type: idxmodel.TypeText},
+	}
+	idx, offset := newTestIndex(t, schema, map[string]storage.Hash{
+		"doc:1": {"name": []byte("banana"), "price": []byte("10")},
+		"doc:2": {"name": []byte("Apple"), "price": []byte("9")},
+		"doc:3": {"name": []byte("cherry")},
+		"doc:4": {"name": []byte("apple"), "price": []byte("100")},
+	})
+	sorted := func(sortBy *SortBy, from int, limit int) *TopNIterator {
+		t.Helper()
+		iter, err := idx.SortBy(sortBy.Field, idx.ReadAll(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return TopN(from, limit, iter, sortBy)
+	}
+
+	// numbers are compared as numbers, documents without the key are last in both directions
+	assertKeys(t, sorted(&SortBy{Field: "price"}, 0, 10), "doc:2", "doc:1", "doc:4", "doc:3")
+	assertKeys(t, sorted(&SortBy{Field: "price", Desc: true}, 0, 10), "doc:4", "doc:1", "doc:2", "doc:3")
+	// strings are case-folded and equal keys are ordered by the document key
+	assertKeys(t, sorted(&SortBy{Field: "name"}, 0, 10), "doc:2", "doc:4", "doc:1", "doc:3")
+	page := sorted(&SortBy{Field: "name", Desc: true}, 1, 2)
+	if page.Total() != 4 {
+		t.Errorf("expected 4 matches, got %d", page.Total())
+	}
+	occ, _, _ := page.Next()
+	if occ.Doc.Key != "doc:1" || occ.SortKey == nil || occ.SortKey.String() != "$banana" {
+		t.Errorf("expected doc:1 with sort key $banana, got %s with %v", occ.Doc.Key, occ.SortKey)
+	}
+	assertKeys(t, page, "doc:2")
+
+	if _, err := idx.SortBy("title", idx.ReadAll(offset)); err == nil {
+		t.Error("expected not sortable field to be rejected")
+	}
+	if _, err := idx.SortBy("missing", idx.ReadAll(offset)); err == nil {
+		t.Error("expected unknown field to be rejected")
+	}
+}
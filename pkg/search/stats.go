@@ -0,0 +1,29 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+type IndexStats struct {
+	index.Stats
+	Prefixes []storage.PrefixStats
+}
+
+// Stats returns statistics of the index and of the documents under its key prefixes
+func (e Engine) Stats(idxName string, topTerms int) (IndexStats, error) {
+	e.mu.RLock()
+	idx, found := e.indexes[idxName]
+	e.mu.RUnlock()
+	if !found {
+		return IndexStats{}, errors.Errorf("Index %s not found", idxName)
+	}
+
+	stats := IndexStats{Stats: idx.Stats(topTerms)}
+	for _, prefix := range idx.Prefixes() {
+		prefixStats, _ := e.s.PrefixStats(prefix)
+		stats.Prefixes = append(stats.Prefixes, prefixStats)
+	}
+	return stats, nil
+}
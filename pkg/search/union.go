@@ -1,60 +1,58 @@
 package search
 
 import (
+	"github.com/emirpasic/gods/trees/binaryheap"
 	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"strings"
 )
 
+// UnionIterator merges two iterators sorted by document key, keeping the next occurrence of each of them
 type UnionIterator struct {
-	iter1       index.TermIterator
-	iter2       index.TermIterator
-	buf         iterBufValue
-	bufHasValue bool
-	drained     bool
+	iter1   index.TermIterator
+	iter2   index.TermIterator
+	head1   iterBufValue
+	head2   iterBufValue
+	ok1     bool
+	ok2     bool
+	started bool
 }
 
 func (u *UnionIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
-	if u.drained == true {
-		ok = false
-		return
+	if !u.started {
+		u.started = true
+		u.advance1()
+		u.advance2()
 	}
 
-	if u.bufHasValue {
-		u.bufHasValue = false
-		return u.buf.occ, u.buf.score, true
+	switch {
+	case !u.ok1 && !u.ok2:
+		return index.DocTermOccurrence{}, 0, false
+	case !u.ok2 || u.ok1 && u.head1.occ.Doc.Key < u.head2.occ.Doc.Key:
+		head := u.head1
+		u.advance1()
+		return head.occ, head.score, true
+	case !u.ok1 || u.head2.occ.Doc.Key < u.head1.occ.Doc.Key:
+		head := u.head2
+		u.advance2()
+		return head.occ, head.score, true
 	}
 
-	occ1, score1, ok1 := u.iter1.Next()
-	occ2, score2, ok2 := u.iter2.Next()
-
-	if !ok1 && !ok2 {
-		u.drained = true
-		ok = false
-		return
-	}
-	if !ok1 {
-		return occ2, score2, true
-	}
-	if !ok2 {
-		return occ1, score1, true
+	buf1, buf2 := u.head1, u.head2
+	u.advance1()
+	u.advance2()
+	result := mergeOccurrences(buf1.occ, buf2.occ)
+	if explaining(buf1, buf2) {
+		result.Explanation = explain(buf1.score+buf2.score, "UNION: sum of scores", buf1, buf2)
 	}
+	return result, buf1.score + buf2.score, true // TODO: 06/05/2023 add penalty for distance ?
+}
 
-	if occ1.Doc == occ2.Doc {
-		fields := occ1.Fields
-		fields.InPlaceUnion(&occ2.Fields)
-		occurrences := append(occ1.Occurrences, occ2.Occurrences...)
-		result := index.DocTermOccurrence{Doc: occ1.Doc, TF: 0, Fields: fields, Occurrences: occurrences}
-		return result, score1 + score2, true // TODO: 06/05/2023 add penalty for distance ?
-	}
-	// skip buffer for the iterator with greater key as the other iterator can return the same key later
-	if occ1.Doc.Key > occ2.Doc.Key {
-		u.buf = iterBufValue{occ: occ1, score: score1}
-		u.bufHasValue = true
-		return occ2, score2, true
-	} else {
-		u.buf = iterBufValue{occ: occ2, score: score2}
-		u.bufHasValue = true
-		return occ1, score1, true
-	}
+func (u *UnionIterator) advance1() {
+	u.head1.occ, u.head1.score, u.ok1 = u.iter1.Next()
+}
+
+func (u *UnionIterator) advance2() {
+	u.head2.occ, u.head2.score, u.ok2 = u.iter2.Next()
 }
 
 func Union(iter1 index.TermIterator, iter2 index.TermIterator) index.TermIterator {
@@ -66,3 +64,73 @@ func Union(iter1 index.TermIterator, iter2 index.TermIterator) index.TermIterato
 	}
 	return &UnionIterator{iter1: iter1, iter2: iter2}
 }
+
+// UnionAllIterator merges any number of iterators sorted by document key using a heap of their heads
+type UnionAllIterator struct {
+	iters []index.TermIterator
+	heads *binaryheap.Heap // of unionHead
+}
+
+type unionHead struct {
+	iterBufValue
+	iter int
+}
+
+func (u *UnionAllIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	if u.heads == nil {
+		u.heads = binaryheap.NewWith(func(a, b interface{}) int {
+			return strings.Compare(a.(unionHead).occ.Doc.Key, b.(unionHead).occ.Doc.Key)
+		})
+		for i := range u.iters {
+			u.advance(i)
+		}
+	}
+
+	v, ok := u.heads.Pop()
+	if !ok {
+		return index.DocTermOccurrence{}, 0, false
+	}
+	head := v.(unionHead)
+	u.advance(head.iter)
+	occurrence, score = head.occ, head.score
+	merged := []iterBufValue{head.iterBufValue}
+	for {
+		v, ok := u.heads.Peek()
+		if !ok || v.(unionHead).occ.Doc.Key != occurrence.Doc.Key {
+			break
+		}
+		u.heads.Pop()
+		next := v.(unionHead)
+		u.advance(next.iter)
+		occurrence = mergeOccurrences(occurrence, next.occ)
+		score += next.score
+		merged = append(merged, next.iterBufValue)
+	}
+	if len(merged) > 1 && explaining(merged...) {
+		occurrence.Explanation = explain(score, "UNION: sum of scores", merged...)
+	}
+	return occurrence, score, true
+}
+
+func (u *UnionAllIterator) advance(i int) {
+	if occ, score, ok := u.iters[i].Next(); ok {
+		u.heads.Push(unionHead{iterBufValue: iterBufValue{occ: occ, score: score}, iter: i})
+	}
+}
+
+// UnionAll merges the iterators, e.g. of the terms a prefix is expanded to. Stop words are skipped.
+func UnionAll(iters []index.TermIterator) index.TermIterator {
+	words := make([]index.TermIterator, 0, len(iters))
+	for _, iter := range iters {
+		if _, ok := iter.(index.StopWordIterator); !ok {
+			words = append(words, iter)
+		}
+	}
+	switch len(words) {
+	case 0:
+		return index.Empty()
+	case 1:
+		return words[0]
+	}
+	return &UnionAllIterator{iters: words}
+}
@@ -0,0 +1,68 @@
+package search
+
+import (
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"testing"
+)
+
+// scored returns iterator over the documents with score 1 each, documents are shared by key between iterators
+func scored(docs map[string]*storage.Document, keys ...string) index.TermIterator {
+	values := make([]iterBufValue, 0, len(keys))
+	for _, key := range keys {
+		if docs[key] == nil {
+			docs[key] = &storage.Document{Key: key}
+		}
+		values = append(values, iterBufValue{occ: index.DocTermOccurrence{Doc: docs[key]}, score: 1})
+	}
+	return &TopNIterator{values: values}
+}
+
+func TestUnionMergesByKey(t *testing.T) {
+	for name, union := range map[string]func(iter1 index.TermIterator, iter2 index.TermIterator) index.TermIterator{
+		"Union": Union,
+		"UnionAll": func(iter1 index.TermIterator, iter2 index.TermIterator) index.TermIterator {
+			return UnionAll([]index.TermIterator{iter1, iter2})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			docs := map[string]*storage.Document{}
+			assertKeys(t, union(scored(docs, "a", "b"), scored(docs, "c")), "a", "b", "c")
+			assertKeys(t, union(scored(docs, "c"), scored(docs, "a", "b")), "a", "b", "c")
+			assertKeys(t, union(scored(docs, "a", "c", "e"), scored(docs, "b", "d")), "a", "b", "c", "d", "e")
+			assertKeys(t, union(scored(docs), scored(docs, "a")), "a")
+
+			iter := union(scored(docs, "a", "b", "d"), scored(docs, "b", "c", "d"))
+			expected := map[string]float32{"a": 1, "b": 2, "c": 1, "d": 2}
+			for _, key := range []string{"a", "b", "c", "d"} {
+				occ, score, ok := iter.Next()
+				if !ok || occ.Doc.Key != key || score != expected[key] {
+					t.Fatalf("expected %s with score %v, got %s with score %v", key, expected[key], occ.Doc.Key, score)
+				}
+			}
+			if _, _, ok := iter.Next(); ok {
+				t.Error("expected union to be drained")
+			}
+		})
+	}
+}
+
+func TestOperatorsOverUnion(t *testing.T) {
+	schema := []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}
+	idx, offset := newTestIndex(t, schema, map[string]storage.Hash{
+		"doc:1": {"title": []byte("apple")},
+		"doc:2": {"title": []byte("banana")},
+		"doc:3": {"title": []byte("cherry")},
+		"doc:4": {"title": []byte("apple banana")},
+		"doc:5": {"title": []byte("apple cherry")},
+	})
+	union := func() index.TermIterator {
+		return Union(idx.Read("banana", offset), idx.Read("apple", offset))
+	}
+
+	assertKeys(t, union(), "doc:1", "doc:2", "doc:4", "doc:5")
+	assertKeys(t, Not(union(), idx.ReadAll(offset)), "doc:3")
+	assertKeys(t, Filter(idx.Read("cherry", offset), union()), "doc:5")
+	assertKeys(t, Intersect(union(), idx.Read("cherry", offset)), "doc:5")
+}
@@ -0,0 +1,29 @@
+package search
+
+import (
+	"fmt"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+)
+
+// WeightIterator multiplies scores of the iterator by the weight
+type WeightIterator struct {
+	iter   index.TermIterator
+	weight float32
+}
+
+func (w *WeightIterator) Next() (occurrence index.DocTermOccurrence, score float32, ok bool) {
+	occurrence, score, ok = w.iter.Next()
+	if ok && occurrence.Explanation != nil {
+		occurrence.Explanation = explain(score*w.weight, fmt.Sprintf("WEIGHT: score * %s", formatScore(w.weight)),
+			iterBufValue{occ: occurrence, score: score})
+	}
+	return occurrence, score * w.weight, ok
+}
+
+func Weight(iter index.TermIterator, weight float32) index.TermIterator {
+	switch iter.(type) {
+	case index.StopWordIterator, *index.AllIterator:
+		return iter
+	}
+	return &WeightIterator{iter: iter, weight: weight}
+}
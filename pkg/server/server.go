@@ -2,11 +2,15 @@ package server
 
 import (
 	"fmt"
+	"github.com/kuzznya/go-redis-search-replica/pkg/index"
+	"github.com/kuzznya/go-redis-search-replica/pkg/memory"
 	"github.com/kuzznya/go-redis-search-replica/pkg/search"
 	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/redcon"
 	"os"
+	"runtime"
 	"runtime/pprof"
 	"strconv"
 	"strings"
@@ -15,12 +19,12 @@ import (
 
 const host = "0.0.0.0"
 
-func StartServer(engine search.Engine, port int) {
+func StartServer(engine search.Engine, s storage.Storage, budget *memory.Budget, port int) {
 	addr := fmt.Sprintf("%s:%d", host, port)
 	log.Infof("Starting server on %s", addr)
 	err := redcon.ListenAndServe(
 		addr,
-		server{engine: engine}.handle,
+		server{engine: engine, s: s, budget: budget}.handle,
 		func(c redcon.Conn) bool { return true },
 		func(c redcon.Conn, err error) {
 			if err != nil {
@@ -37,6 +41,8 @@ func StartServer(engine search.Engine, port int) {
 
 type server struct {
 	engine search.Engine
+	s      storage.Storage
+	budget *memory.Budget
 }
 
 var memprof *os.File
@@ -69,6 +75,12 @@ func (s server) handle(conn redcon.Conn, cmd redcon.Command) {
 	case "ft.search":
 		s.handleFtSearch(conn, args[1:])
 		return
+	case "ft.stats":
+		s.handleFtStats(conn, args[1:])
+		return
+	case "info":
+		s.handleInfo(conn, args[1:])
+		return
 	case "quit":
 		conn.WriteString("OK")
 		_ = conn.Close()
@@ -88,7 +100,7 @@ func (s server) handleFtSearch(conn redcon.Conn, args []string) {
 		conn.WriteError("Wrong number of arguments provided")
 		return
 	}
-	index := args[0]
+	idxName := args[0]
 	query := args[1]
 
 	pos := 1
@@ -99,8 +111,19 @@ func (s server) handleFtSearch(conn redcon.Conn, args []string) {
 		}
 		return "", false
 	}
+	peek := func() string {
+		if pos+1 < len(args) {
+			return strings.ToLower(args[pos+1])
+		}
+		return ""
+	}
 
-	var limit *search.Limit
+	opts := search.Options{}
+	noContent := false
+	withScores := false
+	withSortKeys := false
+	// nil returns the whole document
+	var returned []returnedField
 
 	for {
 		arg, ok := next()
@@ -130,35 +153,465 @@ func (s server) handleFtSearch(conn redcon.Conn, args []string) {
 				conn.WriteError("LIMIT requires two numeric arguments")
 				return
 			}
-			limit = &search.Limit{Offset: offset, Num: num}
+			if offset < 0 || num < 0 {
+				conn.WriteError("LIMIT arguments must not be negative")
+				return
+			}
+			opts.Limit = &search.Limit{Offset: offset, Num: num}
+		case "geofilter":
+			geoArgs := make([]string, 5)
+			for i := range geoArgs {
+				if geoArgs[i], ok = next(); !ok {
+					conn.WriteError("GEOFILTER requires field, lon, lat, radius and unit arguments")
+					return
+				}
+			}
+			r, err := index.ParseGeoRadius(geoArgs[1], geoArgs[2], geoArgs[3], geoArgs[4])
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			opts.GeoFilters = append(opts.GeoFilters, search.GeoFilter{Field: geoArgs[0], GeoRadius: r})
+		case "hybrid":
+			h, err := parseHybrid(next, peek)
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			opts.Hybrid = h
+		case "params":
+			countStr, _ := next()
+			count, err := strconv.Atoi(countStr)
+			if err != nil || count < 0 || count%2 != 0 {
+				conn.WriteError("PARAMS requires an even number of arguments")
+				return
+			}
+			opts.Params = make(map[string]string, count/2)
+			for i := 0; i < count/2; i++ {
+				name, ok := next()
+				value, ok2 := next()
+				if !ok || !ok2 {
+					conn.WriteError("PARAMS requires an even number of arguments")
+					return
+				}
+				opts.Params[name] = value
+			}
+		case "slop":
+			slopStr, _ := next()
+			slop, err := strconv.Atoi(slopStr)
+			if err != nil || slop < 0 {
+				conn.WriteError("SLOP requires a non-negative numeric argument")
+				return
+			}
+			opts.Slop = &slop
+		case "inorder":
+			opts.InOrder = true
+		case "nocontent":
+			noContent = true
+		case "withscores":
+			withScores = true
+		case "explainscore":
+			opts.ExplainScore = true
+		case "sortby":
+			field, ok := next()
+			if !ok {
+				conn.WriteError("SORTBY requires a field argument")
+				return
+			}
+			opts.SortBy = &search.SortBy{Field: field}
+			switch peek() {
+			case "asc":
+				next()
+			case "desc":
+				next()
+				opts.SortBy.Desc = true
+			}
+		case "withsortkeys":
+			withSortKeys = true
+		case "highlight":
+			h, err := parseHighlight(next, peek)
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			opts.Highlight = h
+		case "return", "load":
+			fields, err := parseReturnedFields(strings.ToUpper(arg), next)
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			if fields == nil {
+				break // LOAD * returns the whole document
+			}
+			if returned == nil {
+				returned = make([]returnedField, 0, len(fields))
+			}
+			returned = append(returned, fields...)
+		case "dialect":
+			dialectStr, _ := next()
+			dialect, err := strconv.Atoi(dialectStr)
+			if err != nil || dialect < 1 || dialect > search.MaxDialect {
+				conn.WriteError(fmt.Sprintf("DIALECT requires a version between 1 and %d", search.MaxDialect))
+				return
+			}
+			opts.Dialect = dialect
 		default:
 			conn.WriteError(fmt.Sprintf("Unknown argument '%s'", arg))
 			return
 		}
 	}
 
+	if opts.ExplainScore && !withScores {
+		conn.WriteError("EXPLAINSCORE must be accompanied with WITHSCORES")
+		return
+	}
+
+	snapshot := s.s.Snapshot()
+	defer snapshot.Release()
+
 	start := time.Now()
-	iter, err := s.engine.Search(index, query, limit)
+	page, err := s.engine.Search(idxName, query, opts, snapshot)
 	if err != nil {
 		panic(err)
 	}
 
 	log.Debugf("Query finished in %s", time.Now().Sub(start))
 
-	docs := make([]*storage.Document, 0)
+	// RETURN 0 returns ids only like NOCONTENT
+	noContent = noContent || returned != nil && len(returned) == 0
+
+	// document bodies are loaded only for the returned page, as storage may keep them on disk
+	results := make([]searchResult, 0)
 	for {
-		occ, _, ok := iter.Next()
+		occ, score, ok := page.Next()
 		if !ok {
 			break
 		}
-		docs = append(docs, occ.Doc)
+		r := searchResult{doc: storage.Document{Key: occ.Doc.Key}, score: score, explanation: occ.Explanation,
+			sortKey: occ.SortKey}
+		if noContent {
+			results = append(results, r)
+			continue
+		}
+		hash, err := s.s.Load(occ.Doc)
+		if err != nil {
+			panic(err)
+		}
+		hash = page.Highlight(occ, hash)
+		if len(occ.Computed) > 0 {
+			computed := make(storage.Hash, len(hash)+len(occ.Computed))
+			for k, v := range hash {
+				computed[k] = v
+			}
+			for k, v := range occ.Computed {
+				computed[k] = v
+			}
+			hash = computed
+		}
+		r.doc.Hash = hash
+		results = append(results, r)
+	}
+
+	// every document is replied as its id optionally followed by the score, the sort key and the fields
+	perDoc := 1
+	if withScores {
+		perDoc++
+	}
+	if withSortKeys {
+		perDoc++
+	}
+	if !noContent {
+		perDoc++
+	}
+	conn.WriteArray(len(results)*perDoc + 1)
+	conn.WriteInt(page.Total())
+	for _, r := range results {
+		conn.WriteBulkString(r.doc.Key)
+		if withScores && opts.ExplainScore {
+			conn.WriteArray(2)
+			conn.WriteBulkString(formatScore(r.score))
+			if r.explanation == nil {
+				r.explanation = &index.Explanation{Score: r.score, Description: "SCORE"}
+			}
+			writeExplanation(conn, r.explanation)
+		} else if withScores {
+			conn.WriteBulkString(formatScore(r.score))
+		}
+		if withSortKeys && r.sortKey != nil {
+			conn.WriteBulkString(r.sortKey.String())
+		} else if withSortKeys {
+			conn.WriteNull()
+		}
+		switch {
+		case noContent:
+		case returned == nil:
+			conn.WriteAny(r.doc.Hash)
+		default:
+			writeReturnedFields(conn, r.doc, returned)
+		}
+	}
+}
+
+type searchResult struct {
+	doc         storage.Document
+	score       float32
+	explanation *index.Explanation
+	sortKey     *index.SortKey
+}
+
+// writeExplanation writes the explanation as its description, or as an array of the description
+// and the explanations of the children
+func writeExplanation(conn redcon.Conn, e *index.Explanation) {
+	description := fmt.Sprintf("%s = %s", formatScore(e.Score), e.Description)
+	if len(e.Children) == 0 {
+		conn.WriteBulkString(description)
+		return
+	}
+	conn.WriteArray(2)
+	conn.WriteBulkString(description)
+	conn.WriteArray(len(e.Children))
+	for _, c := range e.Children {
+		writeExplanation(conn, c)
+	}
+}
+
+func formatScore(score float32) string {
+	return strconv.FormatFloat(float64(score), 'g', -1, 32)
+}
+
+// parseHighlight parses HIGHLIGHT [FIELDS n field ...] [TAGS open close]
+func parseHighlight(next func() (string, bool), peek func() string) (*search.Highlight, error) {
+	h := &search.Highlight{Open: search.DefaultHighlightOpen, Close: search.DefaultHighlightClose}
+	for {
+		switch peek() {
+		case "fields":
+			next()
+			countStr, _ := next()
+			count, err := strconv.Atoi(countStr)
+			if err != nil || count <= 0 {
+				return nil, errors.New("HIGHLIGHT FIELDS requires a positive number of fields")
+			}
+			h.Fields = make([]string, count)
+			for i := range h.Fields {
+				field, ok := next()
+				if !ok {
+					return nil, errors.Errorf("HIGHLIGHT FIELDS has less fields than defined num %d", count)
+				}
+				h.Fields[i] = field
+			}
+		case "tags":
+			next()
+			openTag, ok := next()
+			closeTag, ok2 := next()
+			if !ok || !ok2 {
+				return nil, errors.New("HIGHLIGHT TAGS requires open and close tags")
+			}
+			h.Open, h.Close = openTag, closeTag
+		default:
+			return h, nil
+		}
 	}
+}
 
-	conn.WriteArray(len(docs)*2 + 1)
-	conn.WriteInt(len(docs))
-	for _, doc := range docs {
-		conn.WriteAny(doc)
+// returnedField is a document field projected by RETURN or LOAD, it is returned under the alias
+type returnedField struct {
+	name  string
+	alias string
+}
+
+// parseReturnedFields parses RETURN count field [AS alias] ... where the count includes AS and aliases,
+// and LOAD count @field [AS alias] ... or LOAD *. Fields do not have to be in the schema.
+// Nil fields are returned for LOAD *.
+func parseReturnedFields(option string, next func() (string, bool)) ([]returnedField, error) {
+	countStr, _ := next()
+	if option == "LOAD" && countStr == "*" {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 0 {
+		return nil, errors.Errorf("%s requires a non-negative number of arguments", option)
+	}
+	args := make([]string, count)
+	for i := range args {
+		var ok bool
+		if args[i], ok = next(); !ok {
+			return nil, errors.Errorf("%s has fewer arguments than %d", option, count)
+		}
 	}
+
+	fields := make([]returnedField, 0, count)
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimPrefix(args[i], "@")
+		f := returnedField{name: name, alias: name}
+		if i+2 < len(args) && strings.ToLower(args[i+1]) == "as" {
+			f.alias = args[i+2]
+			i += 2
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// writeReturnedFields writes the projected fields the document has
+func writeReturnedFields(conn redcon.Conn, doc storage.Document, fields []returnedField) {
+	present := make([]returnedField, 0, len(fields))
+	for _, f := range fields {
+		if _, ok := doc.Hash[f.name]; ok {
+			present = append(present, f)
+		}
+	}
+	conn.WriteArray(len(present) * 2)
+	for _, f := range present {
+		conn.WriteBulkString(f.alias)
+		conn.WriteBulk(doc.Hash[f.name])
+	}
+}
+
+// parseHybrid parses HYBRID @field $param [K k] [FUSION RRF|LINEAR] [CONSTANT c] [ALPHA a] [FILTER query]
+func parseHybrid(next func() (string, bool), peek func() string) (*search.Hybrid, error) {
+	field, ok := next()
+	if !ok || !strings.HasPrefix(field, "@") {
+		return nil, errors.New("HYBRID requires a vector field reference")
+	}
+	param, ok := next()
+	if !ok || !strings.HasPrefix(param, "$") {
+		return nil, errors.New("HYBRID requires a vector parameter reference")
+	}
+	h := &search.Hybrid{
+		Field: strings.TrimPrefix(field, "@"),
+		Param: param,
+		K:     search.DefaultHybridK,
+		Fusion: search.Fusion{
+			Method:   search.FusionRRF,
+			Constant: search.DefaultRRFConstant,
+			Alpha:    search.DefaultAlpha,
+		},
+	}
+
+	number := func(name string, positive bool) (float64, error) {
+		str, _ := next()
+		n, err := strconv.ParseFloat(str, 64)
+		if err != nil || n < 0 || positive && n == 0 {
+			return 0, errors.Errorf("HYBRID %s requires a valid numeric argument", name)
+		}
+		return n, nil
+	}
+	for {
+		var err error
+		switch peek() {
+		case "k":
+			next()
+			var k float64
+			k, err = number("K", true)
+			h.K = int(k)
+		case "fusion":
+			next()
+			method, _ := next()
+			h.Fusion.Method = strings.ToUpper(method)
+			if h.Fusion.Method != search.FusionRRF && h.Fusion.Method != search.FusionLinear {
+				return nil, errors.New("HYBRID FUSION must be RRF or LINEAR")
+			}
+		case "constant":
+			next()
+			h.Fusion.Constant, err = number("CONSTANT", false)
+		case "alpha":
+			next()
+			h.Fusion.Alpha, err = number("ALPHA", false)
+			if err == nil && h.Fusion.Alpha > 1 {
+				err = errors.New("HYBRID ALPHA must be between 0 and 1")
+			}
+		case "filter":
+			next()
+			if h.Filter, ok = next(); !ok {
+				return nil, errors.New("HYBRID FILTER requires a query argument")
+			}
+		default:
+			return h, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s server) handleFtStats(conn redcon.Conn, args []string) {
+	if len(args) != 1 && len(args) != 3 {
+		conn.WriteError("Wrong number of arguments provided")
+		return
+	}
+	topTerms := 10
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if strings.ToLower(args[1]) != "top" || err != nil || n < 0 {
+			conn.WriteError("TOP requires a non-negative numeric argument")
+			return
+		}
+		topTerms = n
+	}
+
+	stats, err := s.engine.Stats(args[0], topTerms)
+	if err != nil {
+		panic(err)
+	}
+
+	topTermsReply := make([]any, len(stats.TopTerms))
+	for i, t := range stats.TopTerms {
+		topTermsReply[i] = []any{t.Term, t.Postings}
+	}
+	prefixesReply := make([]any, len(stats.Prefixes))
+	for i, p := range stats.Prefixes {
+		prefixesReply[i] = []any{"prefix", p.Prefix, "num_docs", p.Docs, "bytes", p.Bytes}
+	}
+	conn.WriteAny([]any{
+		"index_name", args[0],
+		"num_docs", stats.Docs,
+		"num_terms", stats.Terms,
+		"num_postings", stats.Postings,
+		"avg_posting_list_length", strconv.FormatFloat(stats.AvgPostingLength, 'f', 2, 64),
+		"postings_bytes", stats.PostingBytes,
+		"positions_bytes", stats.PositionBytes,
+		"top_terms", topTermsReply,
+		"prefixes", prefixesReply,
+	})
+}
+
+func (s server) handleInfo(conn redcon.Conn, args []string) {
+	section := "default"
+	if len(args) > 0 {
+		section = strings.ToLower(args[0])
+	}
+	if section != "default" && section != "all" && section != "everything" && section != "memory" {
+		conn.WriteBulkString("")
+		return
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	used := s.budget.Used()
+	info := strings.Builder{}
+	info.WriteString("# Memory\r\n")
+	writeInfoField(&info, "used_memory", strconv.FormatUint(stats.HeapAlloc, 10))
+	writeInfoField(&info, "used_memory_human", memory.FormatSize(int64(stats.HeapAlloc)))
+	writeInfoField(&info, "used_memory_sys", strconv.FormatUint(stats.Sys, 10))
+	writeInfoField(&info, "used_memory_dataset", strconv.FormatInt(used, 10))
+	writeInfoField(&info, "used_memory_dataset_human", memory.FormatSize(used))
+	for _, u := range s.budget.Usages() {
+		writeInfoField(&info, "used_memory_"+strings.ReplaceAll(u.Name, ":", "_"), strconv.FormatInt(u.Bytes, 10))
+	}
+	writeInfoField(&info, "maxmemory", strconv.FormatInt(s.budget.Limit(), 10))
+	writeInfoField(&info, "maxmemory_human", memory.FormatSize(s.budget.Limit()))
+	writeInfoField(&info, "maxmemory_policy", string(s.budget.Policy()))
+	writeInfoField(&info, "maxmemory_exceeded", strconv.FormatBool(s.budget.Exceeded()))
+	conn.WriteBulkString(info.String())
+}
+
+func writeInfoField(info *strings.Builder, name string, value string) {
+	info.WriteString(name)
+	info.WriteString(":")
+	info.WriteString(value)
+	info.WriteString("\r\n")
 }
 
 func handleCommandDocs(conn redcon.Conn, args []string) {
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"fmt"
+	"github.com/kuzznya/go-redis-search-replica/pkg/idxmodel"
+	"github.com/kuzznya/go-redis-search-replica/pkg/memory"
+	"github.com/kuzznya/go-redis-search-replica/pkg/search"
+	"github.com/kuzznya/go-redis-search-replica/pkg/storage"
+	"github.com/tidwall/redcon"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIndex = "idx"
+
+// recordingConn records the replies written to the connection, arrays as *n, integers as :n,
+// nulls as (nil), errors as -message and hashes as {field=value ...} with sorted fields
+type recordingConn struct {
+	redcon.Conn
+	replies []string
+}
+
+func (c *recordingConn) WriteArray(count int) {
+	c.replies = append(c.replies, fmt.Sprintf("*%d", count))
+}
+
+func (c *recordingConn) WriteInt(num int) {
+	c.replies = append(c.replies, fmt.Sprintf(":%d", num))
+}
+
+func (c *recordingConn) WriteBulkString(bulk string) {
+	c.replies = append(c.replies, bulk)
+}
+
+func (c *recordingConn) WriteBulk(bulk []byte) {
+	c.replies = append(c.replies, string(bulk))
+}
+
+func (c *recordingConn) WriteNull() {
+	c.replies = append(c.replies, "(nil)")
+}
+
+func (c *recordingConn) WriteError(msg string) {
+	c.replies = append(c.replies, "-"+msg)
+}
+
+func (c *recordingConn) WriteAny(v interface{}) {
+	hash, ok := v.(storage.Hash)
+	if !ok {
+		c.replies = append(c.replies, fmt.Sprint(v))
+		return
+	}
+	fields := make([]string, 0, len(hash))
+	for field, value := range hash {
+		fields = append(fields, fmt.Sprintf("%s=%s", field, value))
+	}
+	sort.Strings(fields)
+	c.replies = append(c.replies, "{"+strings.Join(fields, " ")+"}")
+}
+
+// newTestServer saves the documents and waits until the index over them is loaded
+func newTestServer(t *testing.T, schema []idxmodel.Field, docs map[string]storage.Hash) server {
+	t.Helper()
+	s := storage.NewMemory()
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s.Begin(1)
+	for _, key := range keys {
+		s.Save(key, docs[key])
+	}
+	s.Commit()
+
+	e := search.NewEngine(s, memory.NewBudget(0, memory.RejectCreate), search.DefaultConfig())
+	if err := e.CreateIndex(testIndex, []string{"doc:"}, schema); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		stats, err := e.Stats(testIndex, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Docs == len(docs) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("index loaded %d of %d documents", stats.Docs, len(docs))
+		}
+	}
+	return server{engine: e, s: s}
+}
+
+func ftSearch(srv server, args ...string) []string {
+	conn := &recordingConn{}
+	srv.handleFtSearch(conn, append([]string{testIndex}, args...))
+	return conn.replies
+}
+
+func assertReplies(t *testing.T, actual []string, expected ...string) {
+	t.Helper()
+	if strings.Join(actual, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected replies %q, got %q", expected, actual)
+	}
+}
+
+func TestFtSearchReturnedFields(t *testing.T) {
+	schema := []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}, {Name: "price", Type: idxmodel.TypeNumeric}}
+	srv := newTestServer(t, schema, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello world"), "body": []byte("text"), "price": []byte("10")},
+		"doc:2": {"title": []byte("hello"), "price": []byte("20")},
+	})
+	// documents matched by numeric ranges have equal scores, so they are ordered by the key
+	query := "@price:[10 20]"
+
+	assertReplies(t, ftSearch(srv, query),
+		"*5", ":2", "doc:1", "{body=text price=10 title=hello world}", "doc:2", "{price=20 title=hello}")
+	assertReplies(t, ftSearch(srv, query, "NOCONTENT"), "*3", ":2", "doc:1", "doc:2")
+	assertReplies(t, ftSearch(srv, query, "RETURN", "0"), "*3", ":2", "doc:1", "doc:2")
+	assertReplies(t, ftSearch(srv, query, "LOAD", "*"),
+		"*5", ":2", "doc:1", "{body=text price=10 title=hello world}", "doc:2", "{price=20 title=hello}")
+	// fields are returned under their aliases in the requested order, missing fields are skipped
+	assertReplies(t, ftSearch(srv, query, "RETURN", "4", "price", "title", "AS", "name"),
+		"*5", ":2",
+		"doc:1", "*4", "price", "10", "name", "hello world",
+		"doc:2", "*4", "price", "20", "name", "hello")
+	// fields do not have to be indexed
+	assertReplies(t, ftSearch(srv, query, "LOAD", "2", "@body", "@price"),
+		"*5", ":2",
+		"doc:1", "*4", "body", "text", "price", "10",
+		"doc:2", "*2", "price", "20")
+	assertReplies(t, ftSearch(srv, query, "RETURN", "1", "missing"), "*5", ":2", "doc:1", "*0", "doc:2", "*0")
+	assertReplies(t, ftSearch(srv, query, "RETURN", "1", "title", "LIMIT", "1", "1"), "*3", ":2", "doc:2", "*2", "title", "hello")
+}
+
+func TestFtSearchScores(t *testing.T) {
+	schema := []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}, {Name: "price", Type: idxmodel.TypeNumeric}}
+	srv := newTestServer(t, schema, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello world"), "price": []byte("10")},
+		"doc:2": {"title": []byte("goodbye"), "price": []byte("20")},
+	})
+
+	// numeric ranges do not score documents, their scores are explained by the score only
+	assertReplies(t, ftSearch(srv, "@price:[10 20]", "NOCONTENT", "WITHSCORES"), "*5", ":2", "doc:1", "0", "doc:2", "0")
+	assertReplies(t, ftSearch(srv, "@price:[10 20]", "WITHSCORES", "RETURN", "1", "price"),
+		"*7", ":2", "doc:1", "0", "*2", "price", "10", "doc:2", "0", "*2", "price", "20")
+	assertReplies(t, ftSearch(srv, "@price:[10 20]", "NOCONTENT", "WITHSCORES", "EXPLAINSCORE"),
+		"*5", ":2", "doc:1", "*2", "0", "0 = SCORE", "doc:2", "*2", "0", "0 = SCORE")
+
+	replies := ftSearch(srv, "hello", "NOCONTENT", "WITHSCORES", "EXPLAINSCORE")
+	if len(replies) != 6 || replies[3] != "*2" || !strings.HasPrefix(replies[5], replies[4]+" = TERM hello: TF ") {
+		t.Fatalf("expected doc:1 with the score explained by the term, got %q", replies)
+	}
+	if score, err := strconv.ParseFloat(replies[4], 32); err != nil || score <= 0 {
+		t.Errorf("expected positive score, got %s", replies[4])
+	}
+}
+
+func TestFtSearchArgumentErrors(t *testing.T) {
+	srv := newTestServer(t, []idxmodel.Field{{Name: "title", Type: idxmodel.TypeText}}, map[string]storage.Hash{
+		"doc:1": {"title": []byte("hello")},
+	})
+
+	for expected, args := range map[string][]string{
+		"-RETURN has fewer arguments than 2":                  {"RETURN", "2", "title"},
+		"-RETURN requires a non-negative number of arguments": {"RETURN", "-1"},
+		"-LOAD requires a non-negative number of arguments":   {"LOAD", "x"},
+		"-EXPLAINSCORE must be accompanied with WITHSCORES":   {"EXPLAINSCORE"},
+	} {
+		assertReplies(t, ftSearch(srv, append([]string{"hello"}, args...)...), expected)
+	}
+}
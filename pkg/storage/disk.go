@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// minCompactSize is the log size below which dead records are never compacted
+const minCompactSize = 16 << 20
+
+// DiskStorage keeps document bodies in an append-only log file and only the key index in memory.
+// Documents returned by GetAll and passed to OnSave have no Hash, it is read from the log by Load.
+// Versions passed to OnDelete have the Hash loaded, so they can be removed from indexes after compaction.
+// The replica always starts with a full resync, so the file is truncated on open. Records of reclaimed versions
+// are counted as dead, once they take more than half of the log it is rewritten with live records only on Commit.
+type DiskStorage struct {
+	path       string
+	f          *os.File
+	size       int64
+	dead       int64 // bytes of records no version refers to, approximate as reclaiming races with compaction
+	compactMin int64
+	m          map[string]*Document
+	onSave     DocumentCallback
+	onDelete   DocumentCallback
+	v          *versions
+	p          *prefixCounters
+	mu         sync.RWMutex // guards the key index, the file and offsets of documents
+	wmu        sync.Mutex
+}
+
+func NewDisk(path string) (*DiskStorage, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open storage log")
+	}
+	noOp := func(*Document) {}
+	s := &DiskStorage{
+		path:       path,
+		f:          f,
+		compactMin: minCompactSize,
+		m:          map[string]*Document{},
+		onSave:     noOp,
+		onDelete:   noOp,
+		v:          newVersions(diskDocSize),
+		p:          newPrefixCounters(),
+	}
+	s.v.onReclaim = s.reclaim
+	return s, nil
+}
+
+// diskDocSize accounts only the key index entry as the document body is kept on disk
+func diskDocSize(d *Document) int64 {
+	return documentOverhead + int64(len(d.Key))
+}
+
+// diskRecordSize adds the encoded hash length to the key index entry for the prefix stats
+func diskRecordSize(d *Document) int64 {
+	return diskDocSize(d) + int64(d.size)
+}
+
+func (s *DiskStorage) OnSave(action DocumentCallback) {
+	s.onSave = action
+}
+
+func (s *DiskStorage) OnDelete(action DocumentCallback) {
+	s.onDelete = action
+}
+
+// reclaim loads the body of the reclaimed version before passing it on, as the version is removed
+// from indexes asynchronously and its record can be compacted away by then
+func (s *DiskStorage) reclaim(d *Document) {
+	hash, err := s.Load(d)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to read reclaimed document %s", d.Key)
+	}
+	d.Hash = hash
+	atomic.AddInt64(&s.dead, int64(d.size))
+	s.onDelete(d)
+}
+
+func (s *DiskStorage) Begin(offset uint64) {
+	s.v.begin(offset)
+}
+
+func (s *DiskStorage) Commit() {
+	s.v.commit()
+	if err := s.compactIfNeeded(); err != nil {
+		log.WithError(err).Panic("Failed to compact storage log")
+	}
+}
+
+func (s *DiskStorage) Snapshot() *Snapshot {
+	return s.v.snapshot()
+}
+
+func (s *DiskStorage) Save(key string, hash Hash) {
+	offset, size, err := s.append(hash)
+	if err != nil {
+		log.WithError(err).Panicf("Failed to save document %s", key)
+	}
+
+	s.saveAt(key, offset, size)
+}
+
+func (s *DiskStorage) saveAt(key string, offset int64, size int) {
+	s.mu.Lock()
+	doc, found := s.m[key]
+	newDoc := &Document{Key: key, Version: s.v.current(), offset: offset, size: size}
+	s.m[key] = newDoc
+	s.mu.Unlock()
+	s.v.add(newDoc)
+	s.p.add(newDoc, diskRecordSize(newDoc))
+	if found {
+		s.v.supersede(doc)
+		s.p.remove(doc, diskRecordSize(doc))
+	}
+	s.onSave(newDoc)
+}
+
+func (s *DiskStorage) Get(key string) (Document, bool) {
+	s.mu.RLock()
+	doc, found := s.m[key]
+	s.mu.RUnlock()
+	if !found {
+		return Document{}, false
+	}
+	hash, err := s.Load(doc)
+	if err != nil {
+		log.WithError(err).Panicf("Failed to read document %s", key)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Document{Key: doc.Key, Hash: hash, Version: doc.Version, offset: doc.offset, size: doc.size}, true
+}
+
+func (s *DiskStorage) Load(d *Document) (Hash, error) {
+	if d.Hash != nil {
+		return d.Hash, nil
+	}
+	buf := make([]byte, d.size)
+	s.mu.RLock()
+	_, err := s.f.ReadAt(buf, d.offset)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read document %s", d.Key)
+	}
+	return decodeHash(buf)
+}
+
+func (s *DiskStorage) Delete(key string) {
+	s.mu.Lock()
+	doc, found := s.m[key]
+	delete(s.m, key)
+	s.mu.Unlock()
+	if found {
+		s.v.supersede(doc)
+		s.p.remove(doc, diskRecordSize(doc))
+	}
+}
+
+// Rename reuses the log record of the renamed document as the body is not changed
+func (s *DiskStorage) Rename(key string, newKey string) {
+	s.mu.RLock()
+	doc, found := s.m[key]
+	s.mu.RUnlock()
+	if found {
+		// the record stays referred to by the new version after the renamed one is reclaimed
+		atomic.AddInt64(&s.dead, -int64(doc.size))
+		s.Delete(key)
+		s.saveAt(newKey, doc.offset, doc.size)
+	}
+}
+
+func (s *DiskStorage) GetAll(prefixes []string) []*Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := make([]*Document, 0)
+	for _, v := range s.m {
+		if !matchesPrefix(prefixes, v.Key) {
+			continue
+		}
+		data = append(data, v)
+	}
+	return data
+}
+
+func (s *DiskStorage) MemoryUsage() int64 {
+	return s.v.memoryUsage()
+}
+
+// TrackPrefix starts maintaining stats of documents with the given key prefix.
+// It should be called by the same goroutine that changes the storage.
+func (s *DiskStorage) TrackPrefix(prefix string) {
+	s.p.track(prefix, s.GetAll([]string{prefix}), diskRecordSize)
+}
+
+func (s *DiskStorage) UntrackPrefix(prefix string) {
+	s.p.untrack(prefix)
+}
+
+func (s *DiskStorage) PrefixStats(prefix string) (PrefixStats, bool) {
+	return s.p.stats(prefix)
+}
+
+func (s *DiskStorage) Close() error {
+	return s.f.Close()
+}
+
+func (s *DiskStorage) compactIfNeeded() error {
+	s.wmu.Lock()
+	size := s.size
+	s.wmu.Unlock()
+	if size < s.compactMin || atomic.LoadInt64(&s.dead) <= size/2 {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact rewrites the log with records of the latest and not reclaimed versions only, keeping their order.
+// Readers are blocked until offsets of all the documents are updated, versions being reclaimed are loaded first.
+func (s *DiskStorage) compact() error {
+	defer s.v.blockReclaim()()
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs := s.v.pending()
+	for _, d := range s.m {
+		docs = append(docs, d)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].offset < docs[j].offset
+	})
+
+	f, err := os.OpenFile(s.path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create compacted storage log")
+	}
+	w := bufio.NewWriter(f)
+	size := int64(0)
+	offsets := make(map[int64]int64, len(docs)) // renamed versions share a record
+	for _, d := range docs {
+		if offset, ok := offsets[d.offset]; ok {
+			d.offset = offset
+			continue
+		}
+		buf := make([]byte, d.size)
+		if _, err = s.f.ReadAt(buf, d.offset); err != nil {
+			_ = f.Close()
+			return errors.Wrapf(err, "failed to read document %s", d.Key)
+		}
+		if _, err = w.Write(buf); err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "failed to write compacted storage log")
+		}
+		offsets[d.offset] = size
+		d.offset = size
+		size += int64(d.size)
+	}
+	if err = w.Flush(); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "failed to write compacted storage log")
+	}
+	if err = os.Rename(f.Name(), s.path); err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "failed to replace storage log")
+	}
+
+	log.Infof("Compacted storage log from %d to %d bytes", s.size, size)
+	old := s.f
+	s.f = f
+	s.size = size
+	atomic.StoreInt64(&s.dead, 0)
+	return old.Close()
+}
+
+func (s *DiskStorage) append(hash Hash) (offset int64, size int, err error) {
+	data := encodeHash(hash)
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	offset = s.size
+	if _, err = s.f.WriteAt(data, offset); err != nil {
+		return 0, 0, errors.Wrap(err, "failed to append to storage log")
+	}
+	s.size += int64(len(data))
+	return offset, len(data), nil
+}
+
+// encodeHash serializes hash as uvarint field count followed by length-prefixed field names and values
+func encodeHash(hash Hash) []byte {
+	data := binary.AppendUvarint(nil, uint64(len(hash)))
+	for k, v := range hash {
+		data = binary.AppendUvarint(data, uint64(len(k)))
+		data = append(data, k...)
+		data = binary.AppendUvarint(data, uint64(len(v)))
+		data = append(data, v...)
+	}
+	return data
+}
+
+func decodeHash(data []byte) (Hash, error) {
+	pos := 0
+	next := func() ([]byte, error) {
+		l, n := binary.Uvarint(data[pos:])
+		if n <= 0 || uint64(len(data)-pos-n) < l {
+			return nil, errors.New("corrupted storage log record")
+		}
+		pos += n
+		v := data[pos : pos+int(l)]
+		pos += int(l)
+		return v, nil
+	}
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errors.New("corrupted storage log record")
+	}
+	pos += n
+
+	hash := make(Hash, count)
+	for i := uint64(0); i < count; i++ {
+		k, err := next()
+		if err != nil {
+			return nil, err
+		}
+		v, err := next()
+		if err != nil {
+			return nil, err
+		}
+		hash[string(k)] = v
+	}
+	return hash, nil
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestDisk(t *testing.T) *DiskStorage {
+	t.Helper()
+	s, err := NewDisk(filepath.Join(t.TempDir(), "storage.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestDiskPrefixStatsCountDocumentBodies(t *testing.T) {
+	s := newTestDisk(t)
+	hash := Hash{"title": []byte("hello world")}
+	s.Begin(1)
+	s.Save("doc:1", hash)
+	s.Commit()
+	s.TrackPrefix("doc:")
+
+	expected := documentOverhead + int64(len("doc:1")) + int64(len(encodeHash(hash)))
+	if stats, _ := s.PrefixStats("doc:"); stats.Docs != 1 || stats.Bytes != expected {
+		t.Fatalf("expected 1 document of %d bytes, got %d of %d bytes", expected, stats.Docs, stats.Bytes)
+	}
+
+	s.Begin(2)
+	s.Save("doc:2", hash)
+	s.Delete("doc:1")
+	s.Commit()
+	expected += int64(len("doc:2")) - int64(len("doc:1"))
+	if stats, _ := s.PrefixStats("doc:"); stats.Docs != 1 || stats.Bytes != expected {
+		t.Fatalf("expected 1 document of %d bytes, got %d of %d bytes", expected, stats.Docs, stats.Bytes)
+	}
+}
+
+func TestDiskCompactionKeepsLiveRecords(t *testing.T) {
+	s := newTestDisk(t)
+	s.compactMin = 0
+	big := Hash{"v": []byte(strings.Repeat("x", 100))}
+	small := func(v string) Hash {
+		return Hash{"v": []byte(v)}
+	}
+
+	// overwritten versions nobody can see are reclaimed on commit and their records are compacted
+	s.Begin(1)
+	for i := 0; i < 10; i++ {
+		s.Save("doc:1", big)
+	}
+	s.Save("doc:2", small("2"))
+	s.Commit()
+	assertLogSize(t, s, big, small("2"))
+
+	// collect dead records with compaction disabled, they are compacted by the next commit under a pinned snapshot
+	s.compactMin = 1 << 30
+	s.Begin(2)
+	for i := 0; i < 10; i++ {
+		s.Save("doc:4", big)
+	}
+	s.Save("doc:4", small("4"))
+	s.Commit()
+
+	snapshot := s.Snapshot()
+	old := s.GetAll([]string{"doc:1"})[0]
+	s.compactMin = 0
+	s.Begin(3)
+	s.Rename("doc:2", "doc:3")
+	s.Save("doc:1", small("new"))
+	s.Commit()
+	// versions pinned by the snapshot keep their records
+	assertLogSize(t, s, big, small("2"), small("new"), small("4"))
+	assertLoaded(t, s, old, "x")
+	assertValue(t, s, "doc:1", "new")
+	assertValue(t, s, "doc:3", "2")
+	assertValue(t, s, "doc:4", "4")
+
+	// the renamed record is still used by the new key
+	snapshot.Release()
+	s.Begin(4)
+	s.Commit()
+	assertLogSize(t, s, small("2"), small("new"), small("4"))
+	assertValue(t, s, "doc:1", "new")
+	assertValue(t, s, "doc:3", "2")
+	assertValue(t, s, "doc:4", "4")
+}
+
+func assertLogSize(t *testing.T, s *DiskStorage, records ...Hash) {
+	t.Helper()
+	expected := int64(0)
+	for _, h := range records {
+		expected += int64(len(encodeHash(h)))
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.size != expected || info.Size() != expected {
+		t.Fatalf("expected log of %d bytes, got %d bytes and file of %d bytes", expected, s.size, info.Size())
+	}
+}
+
+func assertLoaded(t *testing.T, s *DiskStorage, d *Document, prefix string) {
+	t.Helper()
+	hash, err := s.Load(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(hash["v"]), prefix) {
+		t.Fatalf("expected %s value to start with %s, got %s", d.Key, prefix, hash["v"])
+	}
+}
+
+func assertValue(t *testing.T, s *DiskStorage, key string, value string) {
+	t.Helper()
+	doc, found := s.Get(key)
+	if !found || string(doc.Hash["v"]) != value {
+		t.Fatalf("expected %s to have value %s, got %v", key, value, doc.Hash)
+	}
+}
+
+func TestDiskLoadWhileCompacting(t *testing.T) {
+	s := newTestDisk(t)
+	s.compactMin = 0
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snapshot := s.Snapshot()
+				for _, d := range s.GetAll([]string{"doc:"}) {
+					if !d.VisibleAt(snapshot.Offset) {
+						continue
+					}
+					if hash, err := s.Load(d); err != nil || string(hash["key"]) != d.Key {
+						t.Errorf("expected %s to be loaded, got %v, %v", d.Key, hash, err)
+					}
+				}
+				snapshot.Release()
+			}
+		}()
+	}
+
+	for offset := uint64(1); offset <= 200; offset++ {
+		s.Begin(offset)
+		key := "doc:" + strconv.Itoa(int(offset%10))
+		s.Save(key, Hash{"key": []byte(key)})
+		s.Commit()
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,126 @@
+package storage
+
+import "sync"
+
+// MemoryStorage keeps all documents together with their hashes in memory
+type MemoryStorage struct {
+	m      map[string]*Document
+	onSave DocumentCallback
+	v      *versions
+	p      *prefixCounters
+	mu     sync.RWMutex
+}
+
+func New() Storage {
+	return NewMemory()
+}
+
+func NewMemory() *MemoryStorage {
+	noOp := func(*Document) {}
+	return &MemoryStorage{m: map[string]*Document{}, onSave: noOp, v: newVersions(memoryDocSize), p: newPrefixCounters()}
+}
+
+func memoryDocSize(d *Document) int64 {
+	return documentOverhead + int64(len(d.Key)) + hashSize(d.Hash)
+}
+
+func (s *MemoryStorage) OnSave(action DocumentCallback) {
+	s.onSave = action
+}
+
+func (s *MemoryStorage) OnDelete(action DocumentCallback) {
+	s.v.onReclaim = action
+}
+
+func (s *MemoryStorage) Begin(offset uint64) {
+	s.v.begin(offset)
+}
+
+func (s *MemoryStorage) Commit() {
+	s.v.commit()
+}
+
+func (s *MemoryStorage) Snapshot() *Snapshot {
+	return s.v.snapshot()
+}
+
+func (s *MemoryStorage) Save(key string, hash Hash) {
+	s.mu.Lock()
+	doc, found := s.m[key]
+	newDoc := &Document{Key: key, Hash: hash, Version: s.v.current()}
+	s.m[key] = newDoc
+	s.mu.Unlock()
+	s.v.add(newDoc)
+	s.p.add(newDoc, memoryDocSize(newDoc))
+	if found {
+		s.v.supersede(doc)
+		s.p.remove(doc, memoryDocSize(doc))
+	}
+	s.onSave(newDoc)
+}
+
+func (s *MemoryStorage) Get(key string) (Document, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if val, found := s.m[key]; found {
+		return Document{Key: val.Key, Hash: val.Hash, Version: val.Version}, true
+	}
+	return Document{}, false
+}
+
+func (s *MemoryStorage) Load(d *Document) (Hash, error) {
+	return d.Hash, nil
+}
+
+func (s *MemoryStorage) Delete(key string) {
+	s.mu.Lock()
+	doc, found := s.m[key]
+	delete(s.m, key)
+	s.mu.Unlock()
+	if found {
+		// the hash is kept until the version is reclaimed, as it can still be read by snapshots
+		s.v.supersede(doc)
+		s.p.remove(doc, memoryDocSize(doc))
+	}
+}
+
+func (s *MemoryStorage) Rename(key string, newKey string) {
+	s.mu.RLock()
+	doc, found := s.m[key]
+	s.mu.RUnlock()
+	if found {
+		s.Delete(key)
+		s.Save(newKey, doc.Hash)
+	}
+}
+
+func (s *MemoryStorage) GetAll(prefixes []string) []*Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := make([]*Document, 0)
+	for _, v := range s.m {
+		if !matchesPrefix(prefixes, v.Key) {
+			continue
+		}
+		data = append(data, v)
+	}
+	return data
+}
+
+func (s *MemoryStorage) MemoryUsage() int64 {
+	return s.v.memoryUsage()
+}
+
+// TrackPrefix starts maintaining stats of documents with the given key prefix.
+// It should be called by the same goroutine that changes the storage.
+func (s *MemoryStorage) TrackPrefix(prefix string) {
+	s.p.track(prefix, s.GetAll([]string{prefix}), memoryDocSize)
+}
+
+func (s *MemoryStorage) UntrackPrefix(prefix string) {
+	s.p.untrack(prefix)
+}
+
+func (s *MemoryStorage) PrefixStats(prefix string) (PrefixStats, bool) {
+	return s.p.stats(prefix)
+}
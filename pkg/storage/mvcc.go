@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot pins a replication offset: document versions visible at Offset are not reclaimed until Release is called
+type Snapshot struct {
+	Offset   uint64
+	v        *versions
+	released int32
+}
+
+func (s *Snapshot) Release() {
+	if !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return
+	}
+	s.v.release(s.Offset)
+}
+
+// versions tracks replication offsets of document versions, pinned snapshots and superseded versions
+// that are waiting to be reclaimed. Superseded versions are reclaimed (passed to onReclaim) once no pinned
+// and no future snapshot can see them.
+type versions struct {
+	offset    uint64 // offset of the changes being applied, new versions are tagged with it
+	applied   uint64 // offset of the last committed changes, new snapshots are taken at it
+	active    map[uint64]int
+	garbage   []*Document // ordered by deletedAt as offsets only grow
+	onReclaim DocumentCallback
+	size      func(d *Document) int64
+	used      int64 // estimated memory used by all versions not reclaimed yet
+	mu        sync.Mutex
+	rmu       sync.RWMutex // held while versions are reclaimed, until all of them are passed to onReclaim
+}
+
+func newVersions(size func(d *Document) int64) *versions {
+	return &versions{active: map[uint64]int{}, onReclaim: func(*Document) {}, size: size}
+}
+
+// add accounts memory used by the new version
+func (v *versions) add(d *Document) {
+	atomic.AddInt64(&v.used, v.size(d))
+}
+
+func (v *versions) memoryUsage() int64 {
+	return atomic.LoadInt64(&v.used)
+}
+
+func (v *versions) begin(offset uint64) {
+	v.mu.Lock()
+	v.offset = offset
+	v.mu.Unlock()
+}
+
+func (v *versions) commit() {
+	v.mu.Lock()
+	v.applied = v.offset
+	v.mu.Unlock()
+	v.reclaim()
+}
+
+func (v *versions) current() uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.offset
+}
+
+// supersede marks the document version as overwritten or deleted at the current offset
+func (v *versions) supersede(d *Document) {
+	v.mu.Lock()
+	atomic.StoreUint64(&d.deletedAt, v.offset+1)
+	v.garbage = append(v.garbage, d)
+	v.mu.Unlock()
+}
+
+// pending returns superseded versions that are not reclaimed yet
+func (v *versions) pending() []*Document {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]*Document(nil), v.garbage...)
+}
+
+func (v *versions) snapshot() *Snapshot {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.active[v.applied]++
+	return &Snapshot{Offset: v.applied, v: v}
+}
+
+func (v *versions) release(offset uint64) {
+	v.mu.Lock()
+	v.active[offset]--
+	if v.active[offset] <= 0 {
+		delete(v.active, offset)
+	}
+	v.mu.Unlock()
+	v.reclaim()
+}
+
+// blockReclaim waits until reclaimed versions are passed to onReclaim and stops reclaiming
+// until the returned function is called
+func (v *versions) blockReclaim() func() {
+	v.rmu.Lock()
+	return v.rmu.Unlock
+}
+
+func (v *versions) reclaim() {
+	v.rmu.RLock()
+	defer v.rmu.RUnlock()
+	v.mu.Lock()
+	horizon := v.applied
+	for offset := range v.active {
+		if offset < horizon {
+			horizon = offset
+		}
+	}
+	n := 0
+	for n < len(v.garbage) && v.garbage[n].DeletedAt() <= horizon {
+		n++
+	}
+	reclaimed := v.garbage[:n]
+	v.garbage = v.garbage[n:]
+	v.mu.Unlock()
+
+	for _, d := range reclaimed {
+		atomic.AddInt64(&v.used, -v.size(d))
+		v.onReclaim(d)
+	}
+}
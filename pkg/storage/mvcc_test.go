@@ -0,0 +1,92 @@
+package storage
+
+import "testing"
+
+func TestSnapshotPinsSupersededVersions(t *testing.T) {
+	s := NewMemory()
+	reclaimed := make([]*Document, 0)
+	s.OnDelete(func(d *Document) {
+		reclaimed = append(reclaimed, d)
+	})
+
+	s.Begin(1)
+	s.Save("doc:1", Hash{"v": []byte("1")})
+	s.Save("doc:2", Hash{"v": []byte("2")})
+	s.Commit()
+	first := s.Snapshot()
+	v1 := s.GetAll([]string{"doc:1"})[0]
+	v2 := s.GetAll([]string{"doc:2"})[0]
+
+	s.Begin(2)
+	s.Save("doc:1", Hash{"v": []byte("1 again")})
+	s.Delete("doc:2")
+	// changes being applied are not visible to new snapshots before the commit
+	snapshot := s.Snapshot()
+	if snapshot.Offset != 1 {
+		t.Errorf("expected snapshot at offset 1 before the commit, got %d", snapshot.Offset)
+	}
+	snapshot.Release()
+	s.Commit()
+	second := s.Snapshot()
+	latest := s.GetAll([]string{"doc:1"})[0]
+
+	if !v1.VisibleAt(first.Offset) || v1.VisibleAt(second.Offset) {
+		t.Error("expected the first version of doc:1 to be visible only to the first snapshot")
+	}
+	if latest.VisibleAt(first.Offset) || !latest.VisibleAt(second.Offset) {
+		t.Error("expected the second version of doc:1 to be visible only to the second snapshot")
+	}
+	if !v2.VisibleAt(first.Offset) || v2.VisibleAt(second.Offset) {
+		t.Error("expected deleted doc:2 to be visible only to the first snapshot")
+	}
+	if len(reclaimed) != 0 {
+		t.Fatalf("expected versions seen by the first snapshot to be kept, got %d reclaimed", len(reclaimed))
+	}
+
+	// releasing twice has no effect on other snapshots at the same offset
+	pinned := s.Snapshot()
+	second.Release()
+	second.Release()
+	first.Release()
+	if len(reclaimed) != 2 || reclaimed[0] != v1 || reclaimed[1] != v2 {
+		t.Fatalf("expected superseded versions of doc:1 and doc:2 to be reclaimed, got %v", reclaimed)
+	}
+	if !latest.VisibleAt(pinned.Offset) {
+		t.Error("expected the latest version to stay visible")
+	}
+	pinned.Release()
+}
+
+func TestVersionsSupersededAtOffsetZero(t *testing.T) {
+	s := NewMemory()
+	reclaimed := 0
+	s.OnDelete(func(d *Document) {
+		reclaimed++
+	})
+
+	// the initial RDB load of FULLRESYNC at offset 0
+	s.Begin(0)
+	s.Save("doc:1", Hash{"v": []byte("1")})
+	overwritten := s.GetAll([]string{"doc:1"})[0]
+	s.Save("doc:1", Hash{"v": []byte("1 again")})
+	s.Save("doc:2", Hash{"v": []byte("2")})
+	deleted := s.GetAll([]string{"doc:2"})[0]
+	s.Delete("doc:2")
+	s.Commit()
+
+	snapshot := s.Snapshot()
+	defer snapshot.Release()
+	latest := s.GetAll([]string{"doc:1"})[0]
+	if overwritten.VisibleAt(snapshot.Offset) || deleted.VisibleAt(snapshot.Offset) {
+		t.Error("expected versions superseded at offset 0 to be invisible")
+	}
+	if !overwritten.Superseded() || !deleted.Superseded() || latest.Superseded() {
+		t.Error("expected only the versions overwritten or deleted at offset 0 to be superseded")
+	}
+	if !latest.VisibleAt(snapshot.Offset) {
+		t.Error("expected the latest version to be visible")
+	}
+	if reclaimed != 2 {
+		t.Errorf("expected 2 reclaimed versions, got %d", reclaimed)
+	}
+}
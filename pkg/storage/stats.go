@@ -0,0 +1,76 @@
+package storage
+
+import "sync"
+
+type PrefixStats struct {
+	Prefix string
+	Docs   int64
+	Bytes  int64
+}
+
+// prefixCounters maintains document counts and sizes of the latest versions per tracked key prefix,
+// so that stats can be read without scanning the storage. Documents are counted when saved and discounted
+// when superseded, both happen in the replication stream together with tracking new prefixes.
+type prefixCounters struct {
+	m    map[string]*PrefixStats
+	refs map[string]int
+	mu   sync.RWMutex
+}
+
+func newPrefixCounters() *prefixCounters {
+	return &prefixCounters{m: map[string]*PrefixStats{}, refs: map[string]int{}}
+}
+
+// track starts counting documents with the prefix, docs are the current documents of the storage matching it
+func (p *prefixCounters) track(prefix string, docs []*Document, size func(d *Document) int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refs[prefix]++
+	if p.refs[prefix] > 1 {
+		return
+	}
+	stats := &PrefixStats{Prefix: prefix}
+	for _, d := range docs {
+		stats.Docs++
+		stats.Bytes += size(d)
+	}
+	p.m[prefix] = stats
+}
+
+func (p *prefixCounters) untrack(prefix string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refs[prefix]--
+	if p.refs[prefix] <= 0 {
+		delete(p.refs, prefix)
+		delete(p.m, prefix)
+	}
+}
+
+func (p *prefixCounters) add(d *Document, size int64) {
+	p.update(d, 1, size)
+}
+
+func (p *prefixCounters) remove(d *Document, size int64) {
+	p.update(d, -1, -size)
+}
+
+func (p *prefixCounters) update(d *Document, docs int64, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for prefix, stats := range p.m {
+		if matchesPrefix([]string{prefix}, d.Key) {
+			stats.Docs += docs
+			stats.Bytes += size
+		}
+	}
+}
+
+func (p *prefixCounters) stats(prefix string) (PrefixStats, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if stats, ok := p.m[prefix]; ok {
+		return *stats, true
+	}
+	return PrefixStats{Prefix: prefix}, false
+}
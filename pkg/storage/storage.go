@@ -3,101 +3,92 @@ package storage
 import (
 	"github.com/tidwall/redcon"
 	"strings"
-	"sync"
+	"sync/atomic"
 )
 
-type Storage struct {
-	m        map[string]*Document
-	onSave   DocumentCallback
-	onDelete DocumentCallback
-	mu       *sync.RWMutex
+// Storage keeps replicated hashes by key and notifies subscribers about document changes.
+// Implementations may keep document bodies outside of memory, so Document.Hash of the documents
+// returned by GetAll or passed to callbacks can be nil - use Load to fetch the hash of such document.
+//
+// Documents are versioned: every change creates a new Document tagged with the replication offset set by Begin,
+// the previous version stays readable by snapshots taken before Commit. OnDelete callback is called
+// when a superseded version is reclaimed, i.e. no snapshot can see it anymore.
+type Storage interface {
+	OnSave(action DocumentCallback)
+	OnDelete(action DocumentCallback)
+	Begin(offset uint64)
+	Commit()
+	Snapshot() *Snapshot
+	Save(key string, hash Hash)
+	Get(key string) (Document, bool)
+	Load(d *Document) (Hash, error)
+	Delete(key string)
+	Rename(key string, newKey string)
+	GetAll(prefixes []string) []*Document
+	MemoryUsage() int64
+	TrackPrefix(prefix string)
+	UntrackPrefix(prefix string)
+	PrefixStats(prefix string) (PrefixStats, bool)
 }
 
 type Document struct {
-	Key     string
-	Hash    Hash
-	Deleted bool
+	Key       string
+	Hash      Hash
+	Version   uint64 // replication offset at which this version was applied
+	deletedAt uint64 // replication offset at which this version was overwritten or deleted plus 1, 0 for the latest version
+	offset    int64  // position of the document body in the disk log, used by DiskStorage only
+	size      int
 }
 
-func (d Document) MarshalRESP() []byte {
-	data := make([]byte, 0)
-	data = redcon.AppendString(data, d.Key)
-	data = redcon.AppendAny(data, d.Hash)
-	return data
+// VisibleAt reports whether this version is the one a snapshot at the given offset sees
+func (d *Document) VisibleAt(offset uint64) bool {
+	return d.Version <= offset && d.DeletedAt() > offset
 }
 
-type Hash map[string][]byte
-
-type DocumentCallback func(d *Document)
-
-func New() Storage {
-	noOp := func(*Document) {}
-	return Storage{m: map[string]*Document{}, onSave: noOp, onDelete: noOp, mu: &sync.RWMutex{}}
+// DeletedAt returns the replication offset at which this version was overwritten or deleted,
+// math.MaxUint64 for the latest version. Versions can be superseded at offset 0 by the initial RDB load.
+func (d *Document) DeletedAt() uint64 {
+	return atomic.LoadUint64(&d.deletedAt) - 1
 }
 
-func (s Storage) OnSave(action DocumentCallback) {
-	s.onSave = action
+// Superseded reports whether this version was overwritten or deleted
+func (d *Document) Superseded() bool {
+	return atomic.LoadUint64(&d.deletedAt) != 0
 }
 
-func (s Storage) OnDelete(action DocumentCallback) {
-	s.onDelete = action
+func (d Document) MarshalRESP() []byte {
+	data := make([]byte, 0)
+	data = redcon.AppendString(data, d.Key)
+	data = redcon.AppendAny(data, d.Hash)
+	return data
 }
 
-func (s Storage) Save(key string, hash Hash) {
-	s.mu.Lock()
-	doc, found := s.m[key]
-	newDoc := &Document{Key: key, Hash: hash, Deleted: false}
-	s.m[key] = newDoc
-	s.mu.Unlock()
-	if found {
-		doc.Deleted = true
-		s.onDelete(doc)
-	}
-	s.onSave(newDoc)
-}
+type Hash map[string][]byte
 
-func (s Storage) Get(key string) (Document, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	if val, found := s.m[key]; found {
-		return *val, true
+// Clone returns a shallow copy of the hash, values are shared as they are never modified in place
+func (h Hash) Clone() Hash {
+	c := make(Hash, len(h))
+	for k, v := range h {
+		c[k] = v
 	}
-	return Document{}, false
+	return c
 }
 
-func (s Storage) Delete(key string) {
-	s.mu.Lock()
-	doc, found := s.m[key]
-	delete(s.m, key)
-	s.mu.Unlock()
-	if found {
-		doc.Deleted = true
-		doc.Hash = nil // TODO: 13/05/2023 ensure this is not causing an issue. This should optimize storage
-		s.onDelete(doc)
-	}
-}
+type DocumentCallback func(d *Document)
 
-func (s Storage) Rename(key string, newKey string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	doc, found := s.m[key]
-	if found {
-		delete(s.m, key)
-		s.m[newKey] = doc
-	}
-}
+// approximate sizes of the Document struct with its key index entry and of a hash map entry,
+// used to estimate memory usage
+const (
+	documentOverhead  = 128
+	hashEntryOverhead = 48
+)
 
-func (s Storage) GetAll(prefixes []string) []*Document {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	data := make([]*Document, 0)
-	for _, v := range s.m {
-		if !matchesPrefix(prefixes, v.Key) {
-			continue
-		}
-		data = append(data, v)
+func hashSize(hash Hash) int64 {
+	size := int64(0)
+	for k, v := range hash {
+		size += int64(len(k) + len(v) + hashEntryOverhead)
 	}
-	return data
+	return size
 }
 
 func matchesPrefix(prefixes []string, key string) bool {